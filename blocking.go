@@ -0,0 +1,95 @@
+package headlessproxy
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// resourceTypes are the resource type names accepted by block_resource_types
+var resourceTypes = map[string]proto.NetworkResourceType{
+	"document":    proto.NetworkResourceTypeDocument,
+	"stylesheet":  proto.NetworkResourceTypeStylesheet,
+	"image":       proto.NetworkResourceTypeImage,
+	"media":       proto.NetworkResourceTypeMedia,
+	"font":        proto.NetworkResourceTypeFont,
+	"script":      proto.NetworkResourceTypeScript,
+	"texttrack":   proto.NetworkResourceTypeTextTrack,
+	"xhr":         proto.NetworkResourceTypeXHR,
+	"fetch":       proto.NetworkResourceTypeFetch,
+	"eventsource": proto.NetworkResourceTypeEventSource,
+	"websocket":   proto.NetworkResourceTypeWebSocket,
+	"manifest":    proto.NetworkResourceTypeManifest,
+	"ping":        proto.NetworkResourceTypePing,
+	"other":       proto.NetworkResourceTypeOther,
+}
+
+// requestBlocker decides which requests a render skips
+type requestBlocker struct {
+	types        map[proto.NetworkResourceType]bool
+	blockDomains []string
+	allowDomains []string
+}
+
+// newRequestBlocker builds the blocker from the configuration. The upstream
+// host is always allowed so allow_domains can't break the page itself.
+func newRequestBlocker(h *HeadlessProxy) *requestBlocker {
+	b := &requestBlocker{
+		types:        make(map[proto.NetworkResourceType]bool),
+		blockDomains: normalizeDomains(h.BlockDomains),
+		allowDomains: normalizeDomains(h.AllowDomains),
+	}
+	for _, name := range h.BlockResourceTypes {
+		if resourceType, ok := resourceTypes[strings.ToLower(name)]; ok {
+			b.types[resourceType] = true
+		}
+	}
+	if len(b.allowDomains) > 0 {
+		if u, err := url.Parse(h.Upstream); err == nil && u.Hostname() != "" {
+			b.allowDomains = append(b.allowDomains, strings.ToLower(u.Hostname()))
+		}
+	}
+	return b
+}
+
+// Blocked reports whether a request should be failed instead of continued.
+// mainFrame tells whether the request comes from the page's main frame.
+func (b *requestBlocker) Blocked(resourceType proto.NetworkResourceType, u *url.URL, mainFrame bool) bool {
+	host := strings.ToLower(u.Hostname())
+	if matchDomain(host, b.blockDomains) {
+		return true
+	}
+
+	// The page itself, including its redirects, is only blocked by domain;
+	// iframe documents are subject to the type and allowed domain rules
+	if resourceType == proto.NetworkResourceTypeDocument && mainFrame {
+		return false
+	}
+	if b.types[resourceType] {
+		return true
+	}
+	return len(b.allowDomains) > 0 && !matchDomain(host, b.allowDomains)
+}
+
+// normalizeDomains lowercases domains and strips leading dots and wildcards
+func normalizeDomains(domains []string) []string {
+	normalized := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		domain = strings.TrimLeft(strings.ToLower(strings.TrimSpace(domain)), "*.")
+		if domain != "" {
+			normalized = append(normalized, domain)
+		}
+	}
+	return normalized
+}
+
+// matchDomain reports whether host is one of the domains or a subdomain of one
+func matchDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
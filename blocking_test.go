@@ -0,0 +1,51 @@
+package headlessproxy
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/go-rod/rod/lib/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestBlocker(t *testing.T) {
+	b := newRequestBlocker(&HeadlessProxy{
+		Upstream:           "https://Site.test",
+		BlockResourceTypes: []string{"Image", "font", "unknown"},
+		BlockDomains:       []string{"ads.example.net"},
+		AllowDomains:       []string{"*.cdn.test"},
+	})
+
+	tests := []struct {
+		name         string
+		resourceType proto.NetworkResourceType
+		url          string
+		mainFrame    bool
+		blocked      bool
+	}{
+		{"page", proto.NetworkResourceTypeDocument, "https://site.test/", true, false},
+		{"page redirected elsewhere", proto.NetworkResourceTypeDocument, "https://login.other.test/", true, false},
+		{"page on a blocked domain", proto.NetworkResourceTypeDocument, "https://ads.example.net/", true, true},
+		{"iframe outside the allowed domains", proto.NetworkResourceTypeDocument, "https://widgets.other.test/", false, true},
+		{"iframe on an allowed domain", proto.NetworkResourceTypeDocument, "https://img.cdn.test/frame.html", false, false},
+		{"script from upstream", proto.NetworkResourceTypeScript, "https://site.test/app.js", true, false},
+		{"script from an allowed subdomain", proto.NetworkResourceTypeScript, "https://js.cdn.test/lib.js", true, false},
+		{"script outside the allowed domains", proto.NetworkResourceTypeScript, "https://tracker.test/t.js", true, true},
+		{"blocked type", proto.NetworkResourceTypeImage, "https://site.test/logo.png", true, true},
+		{"blocked subdomain", proto.NetworkResourceTypeXHR, "https://a.ads.example.net/x", false, true},
+		{"lookalike domain", proto.NetworkResourceTypeXHR, "https://badcdn.test/x", false, true},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, tt.blocked, b.Blocked(tt.resourceType, u, tt.mainFrame), tt.name)
+	}
+
+	// Without allow_domains, only types and blocked domains count
+	b = newRequestBlocker(&HeadlessProxy{Upstream: "https://site.test", BlockResourceTypes: []string{"document"}})
+	assert.False(t, b.Blocked(proto.NetworkResourceTypeScript, &url.URL{Host: "any.test"}, false))
+	assert.False(t, b.Blocked(proto.NetworkResourceTypeDocument, &url.URL{Host: "site.test"}, true))
+	assert.True(t, b.Blocked(proto.NetworkResourceTypeDocument, &url.URL{Host: "widgets.test"}, false))
+}
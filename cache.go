@@ -5,95 +5,274 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/caddyserver/caddy/v2"
 	"go.uber.org/zap"
 )
 
+// CacheBackend stores rendered responses by cache key
+type CacheBackend interface {
+	// Get returns the entry stored under key, expired or not
+	Get(key string) (*cacheEntry, bool)
+
+	// Set stores an entry under key, replacing any previous one
+	Set(key string, entry *cacheEntry) error
+
+	// Delete removes the entry stored under key
+	Delete(key string) bool
+
+	// Purge removes the entries match selects, or all entries if match is
+	// nil, and returns how many were removed. Backends that don't keep
+	// content in memory pass entries without it.
+	Purge(match func(key string, entry *cacheEntry) bool) int
+
+	// Stats reports the size of the cache
+	Stats() CacheStats
+}
+
+// CacheStats describes the content of a cache backend
+type CacheStats struct {
+	Entries   int   `json:"entries"`
+	Bytes     int64 `json:"bytes"`
+	Evictions int64 `json:"evictions"`
+}
+
 // cacheEntry represents a cached response
 type cacheEntry struct {
-	Content    []byte
-	Headers    http.Header
-	StatusCode int
-	Expires    time.Time
+	Content    []byte      `json:"-"`
+	Headers    http.Header `json:"headers"`
+	StatusCode int         `json:"status_code"`
+	Expires    time.Time   `json:"expires"`
+
+	// How long after expiring the entry may be served while it is refreshed, or when rendering fails
+	StaleWhileRevalidate time.Duration `json:"stale_while_revalidate,omitempty"`
+	StaleIfError         time.Duration `json:"stale_if_error,omitempty"`
+
+	// When the entry was stored, and how old the upstream response already was then
+	Created    time.Time     `json:"created"`
+	InitialAge time.Duration `json:"initial_age,omitempty"`
+
+	// Validators of the upstream document, for conditional revalidation
+	UpstreamETag         string `json:"upstream_etag,omitempty"`
+	UpstreamLastModified string `json:"upstream_last_modified,omitempty"`
+
+	// URL the response was rendered for, and surrogate keys from upstream, for purging
+	URL  string   `json:"url"`
+	Tags []string `json:"tags,omitempty"`
+
+	// Request headers the response varies on. Entries with these set hold no
+	// response but point to the variants stored under variantKey.
+	Vary []string `json:"vary,omitempty"`
+
+	// Number of responses served from the entry, shared by the copies a backend hands out
+	hits *atomic.Int64
+}
+
+// hit counts a response served from the entry
+func (e *cacheEntry) hit() {
+	if e.hits != nil {
+		e.hits.Add(1)
+	}
+}
+
+// hitCount returns the number of responses served from the entry
+func (e *cacheEntry) hitCount() int64 {
+	if e.hits == nil {
+		return 0
+	}
+	return e.hits.Load()
+}
+
+// revalidatable reports whether the entry can be revalidated with a conditional upstream request
+func (e *cacheEntry) revalidatable() bool {
+	return e.UpstreamETag != "" || e.UpstreamLastModified != ""
+}
+
+// age returns the age of the entry's response, for the Age header
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	return e.InitialAge + now.Sub(e.Created)
+}
+
+// fresh reports whether the entry has not expired yet
+func (e *cacheEntry) fresh(now time.Time) bool {
+	return now.Before(e.Expires)
+}
+
+// staleWhileRevalidate reports whether the expired entry may be served while it is refreshed
+func (e *cacheEntry) staleWhileRevalidate(now time.Time) bool {
+	return !e.fresh(now) && now.Before(e.Expires.Add(e.StaleWhileRevalidate))
+}
+
+// staleIfError reports whether the expired entry may be served when rendering fails
+func (e *cacheEntry) staleIfError(now time.Time) bool {
+	return !e.fresh(now) && now.Before(e.Expires.Add(e.StaleIfError))
+}
+
+// size estimates the memory used by an entry stored under key
+func (e *cacheEntry) size(key string) int64 {
+	size := int64(len(key) + len(e.Content))
+	for name, values := range e.Headers {
+		size += int64(len(name))
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+	return size
 }
 
 // getCacheKey generates a cache key for a request
 func (h *HeadlessProxy) getCacheKey(r *http.Request) string {
+	source := h.cacheKeySource(r)
+	if source == "" {
+		return ""
+	}
+
+	// Hash the key to make it a reasonable length
+	hasher := md5.New()
+	hasher.Write([]byte(source))
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// cacheKeySource describes everything a cached response depends on, before hashing.
+// It is empty for requests that are not cached.
+func (h *HeadlessProxy) cacheKeySource(r *http.Request) string {
 	// Only cache GET requests
 	if r.Method != http.MethodGet {
 		return ""
 	}
 
-	// Create a unique key based on the URL and selected headers
-	key := r.URL.String()
+	// Routes that forbid caching get no key
+	if rule := h.matchCacheRule(r); rule != nil && rule.Action == "forbid" {
+		return ""
+	}
+
+	// Each output format and emulated environment is cached separately
+	opts := h.getRenderOptions(r)
+	if opts.Format == "performance" || opts.NoCache {
+		// Measurements describe a single render and are never reused
+		return ""
+	}
+
+	// Start from the page, with a normalized query
+	key := h.cacheKeyBase(r)
+	if query := h.cacheKeyQuery(r); query != "" {
+		key += "?" + query
+	}
 
-	// Add important headers to the cache key
-	headerKeys := []string{"Accept-Language", "User-Agent"}
-	for _, headerKey := range headerKeys {
+	key += "|format:" + opts.Format + "|profile:" + opts.Profile
+	key += "|locale:" + opts.Locale + "|tz:" + opts.Timezone
+	if opts.Geolocation != nil {
+		key += "|geo:" + opts.Geolocation.String()
+	}
+	if media := opts.mediaKey(); media != "" {
+		key += "|media:" + media
+	}
+	if opts.Throttle != "" {
+		key += "|throttle:" + opts.Throttle
+	}
+	if opts.WaitFor != "" {
+		key += "|wait:" + opts.WaitFor
+	}
+
+	// The client's User-Agent only matters when it is presented to upstream
+	if h.ForwardUserAgent && opts.UserAgent != "" {
+		key += "|ua:" + opts.UserAgent
+	}
+
+	// Forwarded headers change what upstream sends
+	for _, headerKey := range h.ForwardHeaders {
 		if value := r.Header.Get(headerKey); value != "" {
 			key += "|" + headerKey + ":" + value
 		}
 	}
 
-	// Add cookies to the cache key if forwarding cookies
-	if h.ForwardCookies {
-		cookies := []string{}
-		for _, cookie := range r.Cookies() {
-			cookies = append(cookies, cookie.Name+"="+cookie.Value)
+	if cookies := h.cacheKeyCookies(r); cookies != "" {
+		key += "|cookies:" + cookies
+	}
+
+	return key
+}
+
+// newCacheBackend creates the configured cache backend
+func (h *HeadlessProxy) newCacheBackend(ctx caddy.Context) (CacheBackend, error) {
+	switch h.CacheStore {
+	case "memory":
+		return newMemoryCache(h.CacheMaxSize), nil
+	case "disk":
+		if h.CacheDir == "" {
+			return nil, fmt.Errorf("cache_store disk requires cache_dir")
 		}
-		if len(cookies) > 0 {
-			key += "|cookies:" + strings.Join(cookies, "&")
+		store, err := newDirStore(h.CacheDir)
+		if err != nil {
+			return nil, err
 		}
+		return newDiskCache(store, h.CacheMaxSize, h.logger)
+	case "storage":
+		store := &storageStore{storage: ctx.Storage(), prefix: "headless_proxy/cache"}
+		return newDiskCache(store, h.CacheMaxSize, h.logger)
+	default:
+		return nil, fmt.Errorf("unsupported cache_store: %s", h.CacheStore)
 	}
+}
 
-	// Hash the key to make it a reasonable length
-	hasher := md5.New()
-	hasher.Write([]byte(key))
-	return hex.EncodeToString(hasher.Sum(nil))
+// cacheStats reports the size of the cache, empty when caching is disabled
+func (h *HeadlessProxy) cacheStats() CacheStats {
+	if h.cacheBackend == nil {
+		return CacheStats{}
+	}
+	return h.cacheBackend.Stats()
 }
 
-// getCachedResponse gets a cached response if available
-func (h *HeadlessProxy) getCachedResponse(r *http.Request) ([]byte, http.Header, int, bool) {
+// getCachedResponse gets a cached response if available, including expired
+// responses that may still be served stale
+func (h *HeadlessProxy) getCachedResponse(r *http.Request) *cacheEntry {
 	// Skip cache if disabled
 	if h.CacheTTL <= 0 {
-		return nil, nil, 0, false
+		return nil
 	}
 
 	// Get cache key
 	key := h.getCacheKey(r)
 	if key == "" {
-		return nil, nil, 0, false
+		return nil
 	}
 
-	h.cacheLock.RLock()
-	defer h.cacheLock.RUnlock()
-
-	// Check if entry exists and is not expired
-	entry, ok := h.cache[key]
-	if !ok || time.Now().After(entry.Expires) {
-		if ok {
-			// Entry exists but is expired
-			h.logger.Debug("cache entry expired", 
-				zap.String("key", key),
-				zap.Time("expires", entry.Expires))
-		}
-		return nil, nil, 0, false
+	// Check if entry exists and is still usable, looking up the variant for
+	// the request if the response varies on request headers
+	entry, ok := h.cacheBackend.Get(key)
+	if ok && len(entry.Vary) > 0 {
+		key = h.variantKey(key, r, entry.Vary)
+		entry, ok = h.cacheBackend.Get(key)
+	}
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	if !entry.fresh(now) && !entry.staleWhileRevalidate(now) && !entry.staleIfError(now) && !entry.revalidatable() {
+		// Entry exists but is past its stale windows and can't be revalidated upstream
+		h.logger.Debug("cache entry expired",
+			zap.String("key", key),
+			zap.Time("expires", entry.Expires))
+		h.cacheBackend.Delete(key)
+		return nil
 	}
 
-	h.logger.Debug("cache hit", 
+	h.logger.Debug("cache hit",
 		zap.String("key", key),
 		zap.Int("content_length", len(entry.Content)),
 		zap.Time("expires", entry.Expires))
 
-	return entry.Content, entry.Headers, entry.StatusCode, true
+	return entry
 }
 
-// setCachedResponse caches a response
-func (h *HeadlessProxy) setCachedResponse(r *http.Request, content []byte, headers http.Header, statusCode int) {
-	// Skip cache if disabled or not a successful response
-	if h.CacheTTL <= 0 || statusCode < 200 || statusCode >= 300 {
+// setCachedResponse caches a rendered response
+func (h *HeadlessProxy) setCachedResponse(r *http.Request, result *renderResult) {
+	// Skip cache if disabled
+	if h.CacheTTL <= 0 {
 		return
 	}
 
@@ -108,59 +287,172 @@ func (h *HeadlessProxy) setCachedResponse(r *http.Request, content []byte, heade
 		return
 	}
 
-	// Check cache control headers
-	if cacheControl := headers.Get("Cache-Control"); cacheControl != "" {
-		if strings.Contains(cacheControl, "no-store") || strings.Contains(cacheControl, "no-cache") {
-			h.logger.Debug("skipping cache due to Cache-Control header", 
-				zap.String("key", key),
-				zap.String("cache_control", cacheControl))
-			return
-		}
+	// Follow the caching headers of the upstream document and the cache rules
+	now := time.Now()
+	ttl, age, ok := h.cacheLifetime(r, result, now)
+	if !ok {
+		h.logger.Debug("response not cacheable",
+			zap.String("key", key),
+			zap.String("cache_control", result.Upstream.Get("Cache-Control")))
+		return
 	}
 
 	// Copy headers to avoid modifying the original
 	headersCopy := make(http.Header)
-	for k, v := range headers {
+	for k, v := range result.Headers {
 		headersCopy[k] = v
 	}
 
-	// Create cache entry
-	entry := cacheEntry{
-		Content:    content,
-		Headers:    headersCopy,
-		StatusCode: statusCode,
-		Expires:    time.Now().Add(time.Duration(h.CacheTTL) * time.Second),
+	// Create cache entry, with the stale windows upstream asks for or the configured ones
+	entry := &cacheEntry{
+		Content:              result.Content,
+		Headers:              headersCopy,
+		StatusCode:           result.StatusCode,
+		Expires:              now.Add(ttl),
+		StaleWhileRevalidate: time.Duration(h.StaleWhileRevalidate) * time.Second,
+		StaleIfError:         time.Duration(h.StaleIfError) * time.Second,
+		Created:              now,
+		InitialAge:           age,
+		UpstreamETag:         result.Upstream.Get("ETag"),
+		UpstreamLastModified: result.Upstream.Get("Last-Modified"),
+		URL:                  requestURL(r),
+		Tags:                 surrogateKeys(result.Upstream),
+	}
+	directives := parseCacheControl(result.Upstream.Get("Cache-Control"))
+	if seconds, ok := directives.seconds("stale-while-revalidate"); ok {
+		entry.StaleWhileRevalidate = seconds
+	}
+	if seconds, ok := directives.seconds("stale-if-error"); ok {
+		entry.StaleIfError = seconds
+	}
+	if _, ok := directives["no-cache"]; ok {
+		// The response must be revalidated before every use, never served stale
+		entry.StaleWhileRevalidate = 0
+		entry.StaleIfError = 0
 	}
 
-	h.cacheLock.Lock()
-	defer h.cacheLock.Unlock()
+	// Responses varying on request headers are stored per variant, behind an
+	// entry naming the headers
+	if vary := varyHeaders(strings.Join(result.Upstream.Values("Vary"), ",")); len(vary) > 0 {
+		marker := &cacheEntry{
+			Expires:              entry.Expires,
+			StaleWhileRevalidate: entry.StaleWhileRevalidate,
+			StaleIfError:         entry.StaleIfError,
+			Created:              now,
+			URL:                  entry.URL,
+			Tags:                 entry.Tags,
+			Vary:                 vary,
+		}
+		if err := h.cacheBackend.Set(key, marker); err != nil {
+			h.logger.Warn("failed to cache response",
+				zap.String("key", key),
+				zap.Error(err))
+			return
+		}
+		key = h.variantKey(key, r, vary)
+	}
 
-	// Store in cache
-	h.cache[key] = entry
-	h.logger.Debug("cached response", 
+	// Store in cache, evicting the least recently used entries if needed
+	if err := h.cacheBackend.Set(key, entry); err != nil {
+		h.logger.Warn("failed to cache response",
+			zap.String("key", key),
+			zap.Error(err))
+		return
+	}
+	h.logger.Debug("cached response",
 		zap.String("key", key),
-		zap.Int("content_length", len(content)),
+		zap.Int("content_length", len(entry.Content)),
 		zap.Time("expires", entry.Expires))
+}
+
+// serveCachedResponse writes a cached response, with the cache status it is served with
+func (h *HeadlessProxy) serveCachedResponse(w http.ResponseWriter, r *http.Request, entry *cacheEntry, status cacheStatus, requestStart time.Time) error {
+	h.logger.Info("serving cached response",
+		zap.String("path", r.URL.Path),
+		zap.Int("status", entry.StatusCode),
+		zap.String("cache_status", string(status)),
+		zap.Duration("response_time", time.Since(requestStart)),
+	)
 
-	// Cleanup old entries if cache is too large (more than 1000 entries)
-	if len(h.cache) > 1000 {
-		h.cleanupCache()
+	for key, values := range entry.Headers {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
 	}
+	entry.hit()
+	h.setCacheStatus(w, r, status, entry)
+
+	// Answer conditional requests from the cache
+	if notModified(r, entry.Headers) {
+		writeNotModified(w, entry.Headers)
+		status := fmt.Sprintf("%d", http.StatusNotModified)
+		h.metrics.requestsTotal.WithLabelValues(r.Method, status).Inc()
+		h.metrics.requestDuration.WithLabelValues(r.Method, status).Observe(time.Since(requestStart).Seconds())
+		h.metrics.responseStatusCode.WithLabelValues(status).Inc()
+		return nil
+	}
+
+	w.WriteHeader(entry.StatusCode)
+	_, err := w.Write(entry.Content)
+
+	// Record metrics
+	status := fmt.Sprintf("%d", entry.StatusCode)
+	h.metrics.requestsTotal.WithLabelValues(r.Method, status).Inc()
+	h.metrics.requestDuration.WithLabelValues(r.Method, status).Observe(time.Since(requestStart).Seconds())
+	h.metrics.responseSize.WithLabelValues(r.Method, status).Observe(float64(len(entry.Content)))
+	h.metrics.responseStatusCode.WithLabelValues(status).Inc()
+
+	return err
 }
 
-// cleanupCache removes expired entries from the cache
-func (h *HeadlessProxy) cleanupCache() {
-	now := time.Now()
-	removed := 0
+// revalidate refreshes the cached response of a request in the background,
+// asking upstream whether the page changed before rendering it again. Only one
+// refresh per cache key runs at a time.
+func (h *HeadlessProxy) revalidate(r *http.Request, opts *renderOptions, cached *cacheEntry) {
+	key := h.getCacheKey(r)
+	if _, running := h.revalidating.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+
+	// The refresh outlives the client request
+	r = h.detachRequest(r, opts)
+	go func() {
+		defer h.revalidating.Delete(key)
 
-	for key, entry := range h.cache {
-		if now.After(entry.Expires) {
-			delete(h.cache, key)
-			removed++
+		_, err := h.renderCoalesced(r, opts, cached)
+		if err != nil {
+			h.logger.Warn("background revalidation failed",
+				zap.String("path", r.URL.Path),
+				zap.Error(err))
 		}
+	}()
+}
+
+// cacheControl holds the directives of a Cache-Control header
+type cacheControl map[string]string
+
+// parseCacheControl parses a Cache-Control header into lowercase directives and their values
+func parseCacheControl(header string) cacheControl {
+	directives := cacheControl{}
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+		directives[strings.ToLower(name)] = strings.Trim(value, `"`)
 	}
+	return directives
+}
 
-	h.logger.Info("cache cleanup completed", 
-		zap.Int("removed", removed),
-		zap.Int("remaining", len(h.cache)))
+// seconds returns the value of a delta-seconds directive
+func (c cacheControl) seconds(name string) (time.Duration, bool) {
+	value, ok := c[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
 }
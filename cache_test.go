@@ -0,0 +1,31 @@
+package headlessproxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheEntryStaleWindows(t *testing.T) {
+	directives := parseCacheControl(`max-age=60, Stale-While-Revalidate=30, stale-if-error="600", no-transform`)
+	swr, ok := directives.seconds("stale-while-revalidate")
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, swr)
+	sie, ok := directives.seconds("stale-if-error")
+	assert.True(t, ok)
+	assert.Equal(t, 10*time.Minute, sie)
+	_, ok = directives.seconds("no-transform")
+	assert.False(t, ok)
+
+	now := time.Now()
+	entry := &cacheEntry{Expires: now, StaleWhileRevalidate: swr, StaleIfError: sie}
+	assert.True(t, entry.fresh(now.Add(-time.Second)))
+	assert.False(t, entry.staleWhileRevalidate(now.Add(-time.Second)))
+
+	// Past expiry, the revalidation window closes before the error window
+	assert.True(t, entry.staleWhileRevalidate(now.Add(10*time.Second)))
+	assert.False(t, entry.staleWhileRevalidate(now.Add(time.Minute)))
+	assert.True(t, entry.staleIfError(now.Add(time.Minute)))
+	assert.False(t, entry.staleIfError(now.Add(time.Hour)))
+}
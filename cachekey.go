@@ -0,0 +1,124 @@
+package headlessproxy
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// defaultQueryDeny drops campaign tracking parameters from cache keys by default
+var defaultQueryDeny = []string{"utm_*"}
+
+// cacheKeyBase identifies the page of a request in its cache key: the
+// cache_key template with placeholders replaced, or the host and path
+func (h *HeadlessProxy) cacheKeyBase(r *http.Request) string {
+	if h.CacheKey == "" {
+		return r.Host + r.URL.Path
+	}
+	repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		repl = caddy.NewReplacer()
+	}
+	return repl.ReplaceAll(h.CacheKey, "")
+}
+
+// cacheKeyQuery normalizes the query of a request for its cache key: denied
+// parameters are dropped, only allowed ones are kept if an allowlist is set,
+// and the rest is sorted by name
+func (h *HeadlessProxy) cacheKeyQuery(r *http.Request) string {
+	return h.normalizeQuery(r.URL.Query())
+}
+
+// normalizeQuery applies the cache key query rules to parsed query parameters
+func (h *HeadlessProxy) normalizeQuery(query url.Values) string {
+	for name := range query {
+		if matchAny(h.CacheKeyQueryDeny, name) || len(h.CacheKeyQueryAllow) > 0 && !matchAny(h.CacheKeyQueryAllow, name) {
+			query.Del(name)
+		}
+	}
+	// Encode sorts by name and keeps the order of repeated values
+	return query.Encode()
+}
+
+// normalizeURL applies the cache key query rules to an absolute URL or a path
+// and query, so URLs differing only in dropped or reordered parameters are equal
+func (h *HeadlessProxy) normalizeURL(u string) string {
+	base, rawQuery, ok := strings.Cut(u, "?")
+	if !ok {
+		return u
+	}
+	// Like the request query, malformed pairs are skipped
+	query, _ := url.ParseQuery(rawQuery)
+	if query := h.normalizeQuery(query); query != "" {
+		return base + "?" + query
+	}
+	return base
+}
+
+// cacheKeyCookies returns the cookies of a request that are part of its
+// cache key: those on the cookie allowlist, or all of them when cookies are
+// forwarded and there is no allowlist
+func (h *HeadlessProxy) cacheKeyCookies(r *http.Request) string {
+	if len(h.CacheKeyCookies) == 0 && !h.ForwardCookies {
+		return ""
+	}
+
+	var cookies []string
+	for _, cookie := range r.Cookies() {
+		if !h.keyCookie(cookie.Name) {
+			continue
+		}
+		cookies = append(cookies, url.QueryEscape(cookie.Name)+"="+url.QueryEscape(cookie.Value))
+	}
+	sort.Strings(cookies)
+	return strings.Join(cookies, "&")
+}
+
+// forwardedCookies returns the cookies of a request passed on to upstream. With
+// a cookie allowlist only those cookies are forwarded, so every cookie that can
+// change a render is part of its cache key.
+func (h *HeadlessProxy) forwardedCookies(r *http.Request) []*http.Cookie {
+	if !h.ForwardCookies {
+		return nil
+	}
+
+	var cookies []*http.Cookie
+	for _, cookie := range r.Cookies() {
+		if h.keyCookie(cookie.Name) {
+			cookies = append(cookies, cookie)
+		}
+	}
+	return cookies
+}
+
+// keyCookie reports whether a cookie passes the cookie allowlist, if one is set
+func (h *HeadlessProxy) keyCookie(name string) bool {
+	return len(h.CacheKeyCookies) == 0 || matchAny(h.CacheKeyCookies, name)
+}
+
+// matchAny reports whether a name matches any of the patterns, where * matches any characters
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if wildcardMatch(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// detachRequest copies a request for work that outlives it, such as a shared
+// or background render, keeping its render options, placeholders and ID
+func (h *HeadlessProxy) detachRequest(r *http.Request, opts *renderOptions) *http.Request {
+	ctx := h.ctx
+	if repl := r.Context().Value(caddy.ReplacerCtxKey); repl != nil {
+		ctx = context.WithValue(ctx, caddy.ReplacerCtxKey, repl)
+	}
+	if requestID := r.Context().Value(requestIDCtxKey{}); requestID != nil {
+		ctx = context.WithValue(ctx, requestIDCtxKey{}, requestID)
+	}
+	return withRenderOptions(r.Clone(ctx), opts)
+}
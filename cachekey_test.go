@@ -0,0 +1,46 @@
+package headlessproxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheKeyQuery(t *testing.T) {
+	h := &HeadlessProxy{CacheKeyQueryDeny: defaultQueryDeny}
+	r := httptest.NewRequest("GET", "/products?sort=price&utm_source=mail&page=2&utm_medium=email&tag=b&tag=a", nil)
+	assert.Equal(t, "page=2&sort=price&tag=b&tag=a", h.cacheKeyQuery(r))
+
+	// Parameter order does not split the cache
+	other := httptest.NewRequest("GET", "/products?tag=b&page=2&tag=a&sort=price", nil)
+	assert.Equal(t, h.cacheKeyQuery(r), h.cacheKeyQuery(other))
+
+	h = &HeadlessProxy{CacheKeyQueryAllow: []string{"page", "filter_*"}}
+	r = httptest.NewRequest("GET", "/products?sort=price&page=2&filter_color=red&session=1", nil)
+	assert.Equal(t, "filter_color=red&page=2", h.cacheKeyQuery(r))
+}
+
+func TestCacheKeyCookies(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Cookie", "session=abc; lang=de; ab_test=b; _ga=GA1.2")
+
+	h := &HeadlessProxy{}
+	assert.Empty(t, h.cacheKeyCookies(r))
+
+	h = &HeadlessProxy{ForwardCookies: true}
+	assert.Equal(t, "_ga=GA1.2&ab_test=b&lang=de&session=abc", h.cacheKeyCookies(r))
+
+	h = &HeadlessProxy{ForwardCookies: true, CacheKeyCookies: []string{"lang", "ab_*"}}
+	assert.Equal(t, "ab_test=b&lang=de", h.cacheKeyCookies(r))
+
+	// Only cookies in the key reach upstream
+	var forwarded []string
+	for _, cookie := range h.forwardedCookies(r) {
+		forwarded = append(forwarded, cookie.Name)
+	}
+	assert.Equal(t, []string{"lang", "ab_test"}, forwarded)
+
+	h = &HeadlessProxy{CacheKeyCookies: []string{"lang"}}
+	assert.Empty(t, h.forwardedCookies(r))
+}
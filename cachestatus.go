@@ -0,0 +1,71 @@
+package headlessproxy
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// cacheStatus describes how the cache took part in a response
+type cacheStatus string
+
+const (
+	// cacheHit is a fresh response served from the cache
+	cacheHit cacheStatus = "HIT"
+	// cacheMiss is a render for a request with nothing cached
+	cacheMiss cacheStatus = "MISS"
+	// cacheStale is an expired response served from the cache
+	cacheStale cacheStatus = "STALE"
+	// cacheBypass is a request the cache does not handle
+	cacheBypass cacheStatus = "BYPASS"
+	// cacheExpired is a render or upstream revalidation replacing an expired response
+	cacheExpired cacheStatus = "EXPIRED"
+)
+
+// cacheStatusName identifies the cache in Cache-Status headers
+const cacheStatusName = "headless_proxy"
+
+// cacheStatusHeader formats the Cache-Status header (RFC 9211) of a response
+func cacheStatusHeader(r *http.Request, status cacheStatus, entry *cacheEntry, now time.Time) string {
+	switch status {
+	case cacheHit, cacheStale:
+		// ttl is the remaining freshness lifetime, negative once expired
+		ttl := int64(entry.Expires.Sub(now) / time.Second)
+		return cacheStatusName + "; hit; ttl=" + strconv.FormatInt(ttl, 10)
+	case cacheExpired:
+		return cacheStatusName + "; fwd=stale"
+	case cacheMiss:
+		return cacheStatusName + "; fwd=miss"
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return cacheStatusName + "; fwd=method"
+	}
+	return cacheStatusName + "; fwd=bypass"
+}
+
+// setCacheStatus describes how the cache took part in a response with the
+// X-Cache, Age and Cache-Status headers, and in placeholders for access logs
+func (h *HeadlessProxy) setCacheStatus(w http.ResponseWriter, r *http.Request, status cacheStatus, entry *cacheEntry) {
+	now := time.Now()
+	age := int64(0)
+	if entry != nil && (status == cacheHit || status == cacheStale) {
+		age = int64(entry.age(now) / time.Second)
+	}
+
+	w.Header().Set("X-Cache", string(status))
+	w.Header().Set("Age", strconv.FormatInt(age, 10))
+	w.Header().Set("Cache-Status", cacheStatusHeader(r, status, entry, now))
+
+	repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		return
+	}
+	repl.Set("http.headless_proxy.cache_status", string(status))
+	repl.Set("http.headless_proxy.cache_age", age)
+	if entry != nil {
+		repl.Set("http.headless_proxy.cache_hits", entry.hitCount())
+		repl.Set("http.headless_proxy.cache_created", entry.Created.UTC().Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,41 @@
+package headlessproxy
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheStatusHeader(t *testing.T) {
+	now := time.Now()
+	get := httptest.NewRequest("GET", "/", nil)
+	entry := &cacheEntry{Expires: now.Add(90 * time.Second)}
+
+	assert.Equal(t, "headless_proxy; hit; ttl=90", cacheStatusHeader(get, cacheHit, entry, now))
+	assert.Equal(t, "headless_proxy; hit; ttl=-30", cacheStatusHeader(get, cacheStale, entry, now.Add(2*time.Minute)))
+	assert.Equal(t, "headless_proxy; fwd=miss", cacheStatusHeader(get, cacheMiss, nil, now))
+	assert.Equal(t, "headless_proxy; fwd=stale", cacheStatusHeader(get, cacheExpired, nil, now))
+	assert.Equal(t, "headless_proxy; fwd=bypass", cacheStatusHeader(get, cacheBypass, nil, now))
+	assert.Equal(t, "headless_proxy; fwd=method", cacheStatusHeader(httptest.NewRequest("POST", "/", nil), cacheBypass, nil, now))
+}
+
+func TestCacheEntryHits(t *testing.T) {
+	cache := newMemoryCache(1 << 20)
+	require.NoError(t, cache.Set("a", &cacheEntry{Content: []byte("page")}))
+
+	for i := 0; i < 3; i++ {
+		entry, ok := cache.Get("a")
+		require.True(t, ok)
+		entry.hit()
+	}
+	entry, _ := cache.Get("a")
+	assert.Equal(t, int64(3), entry.hitCount())
+
+	// A new response for the key starts counting again
+	require.NoError(t, cache.Set("a", &cacheEntry{Content: []byte("page")}))
+	entry, _ = cache.Get("a")
+	assert.Equal(t, int64(0), entry.hitCount())
+}
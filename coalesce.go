@@ -0,0 +1,104 @@
+package headlessproxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// renderCall is a render in flight, shared by the requests waiting for it
+type renderCall struct {
+	done   chan struct{}
+	result *renderResult
+	err    error
+
+	// Number of requests waiting for the render
+	dups int
+}
+
+// renderGroup collapses concurrent renders with the same key into one
+type renderGroup struct {
+	mu    sync.Mutex
+	calls map[string]*renderCall
+}
+
+// Do runs fn unless a call with the same key is in flight, in which case it
+// waits up to timeout for that call's result. shared reports whether the
+// result came from another call.
+func (g *renderGroup) Do(ctx context.Context, key string, timeout time.Duration, fn func() (*renderResult, error)) (result *renderResult, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*renderCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		call.dups++
+		g.mu.Unlock()
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-call.done:
+			return call.result, true, call.err
+		case <-timer.C:
+			return nil, true, fmt.Errorf("%w: waiting for a render in flight", ErrTimeout)
+		case <-ctx.Done():
+			return nil, true, ctx.Err()
+		}
+	}
+	call := &renderCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	// Release the waiters however fn ends
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	defer func() {
+		// Waiters get an error for a panicking render, the caller the panic
+		if p := recover(); p != nil {
+			call.result, call.err = nil, fmt.Errorf("render panicked: %v", p)
+			panic(p)
+		}
+	}()
+
+	call.result, call.err = fn()
+	return call.result, false, call.err
+}
+
+// renderCoalesced renders a request and caches the response. Requests with the
+// same cache key arriving meanwhile wait for this render instead of starting their own.
+// An expired cached response is first revalidated upstream, skipping the render if
+// the page did not change.
+func (h *HeadlessProxy) renderCoalesced(r *http.Request, opts *renderOptions, cached *cacheEntry) (*renderResult, error) {
+	key := h.getCacheKey(r)
+	if key == "" {
+		return h.render(r, opts)
+	}
+
+	result, shared, err := h.renders.Do(r.Context(), key, time.Duration(h.CoalesceTimeout)*time.Second, func() (*renderResult, error) {
+		// Other clients wait for this render, so it must not stop when this one goes away
+		shared := h.detachRequest(r, opts)
+		if cached != nil {
+			if result, ok := h.revalidateUpstream(shared, cached); ok {
+				h.setCachedResponse(shared, result)
+				return result, nil
+			}
+		}
+
+		result, err := h.render(shared, opts)
+		if err == nil {
+			// Cache before the waiters are released, so later requests find the response
+			h.setCachedResponse(shared, result)
+		}
+		return result, err
+	})
+	if shared {
+		h.metrics.coalescedRequestsTotal.Inc()
+	}
+	return result, err
+}
@@ -0,0 +1,117 @@
+package headlessproxy
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitForWaiters blocks until n requests wait for the render in flight for key
+func waitForWaiters(group *renderGroup, key string, n int) {
+	for {
+		group.mu.Lock()
+		call, ok := group.calls[key]
+		waiting := ok && call.dups >= n
+		group.mu.Unlock()
+		if waiting {
+			return
+		}
+		runtime.Gosched()
+	}
+}
+
+func TestRenderGroup(t *testing.T) {
+	var group renderGroup
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	renders := 0
+	render := func() (*renderResult, error) {
+		renders++
+		close(entered)
+		<-release
+		return &renderResult{Content: []byte("rendered")}, nil
+	}
+
+	// The first call renders while the others wait for it
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, shared, err := group.Do(context.Background(), "key", time.Second, render)
+		assert.NoError(t, err)
+		assert.False(t, shared)
+	}()
+	<-entered
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, shared, err := group.Do(context.Background(), "key", time.Second, render)
+			assert.NoError(t, err)
+			assert.True(t, shared)
+			assert.Equal(t, []byte("rendered"), result.Content)
+		}()
+	}
+	waitForWaiters(&group, "key", 10)
+	close(release)
+	wg.Wait()
+	<-done
+	assert.Equal(t, 1, renders)
+
+	// Waiters give up after their timeout
+	slowEntered := make(chan struct{})
+	block := make(chan struct{})
+	defer close(block)
+	go group.Do(context.Background(), "slow", time.Second, func() (*renderResult, error) {
+		close(slowEntered)
+		<-block
+		return nil, nil
+	})
+	<-slowEntered
+	_, shared, err := group.Do(context.Background(), "slow", 10*time.Millisecond, render)
+	assert.True(t, shared)
+	assert.True(t, errors.Is(err, ErrTimeout))
+}
+
+func TestRenderGroupPanic(t *testing.T) {
+	var group renderGroup
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	// The panic reaches the rendering caller
+	panicked := make(chan interface{})
+	go func() {
+		defer func() { panicked <- recover() }()
+		group.Do(context.Background(), "key", time.Second, func() (*renderResult, error) {
+			close(entered)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-entered
+
+	// Waiters get an error instead of waiting for their timeout
+	waited := make(chan error)
+	go func() {
+		_, _, err := group.Do(context.Background(), "key", time.Minute, nil)
+		waited <- err
+	}()
+	waitForWaiters(&group, "key", 1)
+	close(release)
+	assert.Equal(t, "boom", <-panicked)
+	assert.Error(t, <-waited)
+
+	// The key is free for the next render
+	result, shared, err := group.Do(context.Background(), "key", time.Second, func() (*renderResult, error) {
+		return &renderResult{Content: []byte("rendered")}, nil
+	})
+	assert.NoError(t, err)
+	assert.False(t, shared)
+	assert.Equal(t, []byte("rendered"), result.Content)
+}
@@ -0,0 +1,129 @@
+package headlessproxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// addValidators sets a strong ETag over the rendered body, and Last-Modified
+// from the upstream document or the time of the render
+func (res *renderResult) addValidators(now time.Time) {
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return
+	}
+
+	sum := sha256.Sum256(res.Content)
+	res.Headers.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+
+	lastModified := now
+	if t, err := http.ParseTime(res.Upstream.Get("Last-Modified")); err == nil {
+		lastModified = t
+	}
+	res.Headers.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+}
+
+// notModified evaluates the conditional headers of a request against the
+// validators of a response. If-None-Match takes precedence over If-Modified-Since.
+func notModified(r *http.Request, headers http.Header) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := headers.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		// If-None-Match uses the weak comparison
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	ifModifiedSince, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(headers.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !lastModified.After(ifModifiedSince)
+}
+
+// writeNotModified answers a conditional request with 304 and the headers a
+// 304 carries, leaving out those describing the body
+func writeNotModified(w http.ResponseWriter, headers http.Header) {
+	for key, values := range headers {
+		switch key {
+		case "Content-Type", "Content-Length", "Content-Encoding":
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// revalidateUpstream asks upstream with a conditional request whether the page
+// of an expired entry changed. If it did not, the cached response is returned
+// for storing again, without a render.
+func (h *HeadlessProxy) revalidateUpstream(r *http.Request, entry *cacheEntry) (*renderResult, bool) {
+	if entry.UpstreamETag == "" && entry.UpstreamLastModified == "" {
+		return nil, false
+	}
+
+	req, err := h.newUpstreamRequest(r.Context(), r, h.targetURL(r))
+	if err != nil {
+		return nil, false
+	}
+	if entry.UpstreamETag != "" {
+		req.Header.Set("If-None-Match", entry.UpstreamETag)
+	}
+	if entry.UpstreamLastModified != "" {
+		req.Header.Set("If-Modified-Since", entry.UpstreamLastModified)
+	}
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		h.metrics.cacheRevalidationsTotal.WithLabelValues("error").Inc()
+		h.logger.Warn("conditional upstream request failed",
+			zap.String("path", r.URL.Path),
+			zap.Error(err))
+		return nil, false
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxRawBodySize))
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotModified {
+		h.metrics.cacheRevalidationsTotal.WithLabelValues("modified").Inc()
+		return nil, false
+	}
+	h.metrics.cacheRevalidationsTotal.WithLabelValues("not_modified").Inc()
+
+	// A 304 updates the caching headers but may leave out the validators
+	upstream := resp.Header.Clone()
+	if upstream.Get("ETag") == "" && entry.UpstreamETag != "" {
+		upstream.Set("ETag", entry.UpstreamETag)
+	}
+	if upstream.Get("Last-Modified") == "" && entry.UpstreamLastModified != "" {
+		upstream.Set("Last-Modified", entry.UpstreamLastModified)
+	}
+	return &renderResult{
+		Content:    entry.Content,
+		Headers:    entry.Headers.Clone(),
+		StatusCode: entry.StatusCode,
+		Upstream:   upstream,
+	}, true
+}
@@ -0,0 +1,48 @@
+package headlessproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConditionalRequests(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	result := &renderResult{
+		Content:    []byte("<html>rendered</html>"),
+		Headers:    http.Header{},
+		StatusCode: http.StatusOK,
+		Upstream:   http.Header{"Last-Modified": {"Tue, 30 Apr 2024 08:00:00 GMT"}},
+	}
+	result.addValidators(now)
+	etag := result.Headers.Get("ETag")
+	assert.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+	assert.Equal(t, "Tue, 30 Apr 2024 08:00:00 GMT", result.Headers.Get("Last-Modified"))
+
+	conditional := func(name, value string) bool {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(name, value)
+		return notModified(r, result.Headers)
+	}
+	assert.True(t, conditional("If-None-Match", etag))
+	assert.True(t, conditional("If-None-Match", `"other", W/`+etag))
+	assert.True(t, conditional("If-None-Match", "*"))
+	assert.False(t, conditional("If-None-Match", `"other"`))
+	assert.True(t, conditional("If-Modified-Since", "Tue, 30 Apr 2024 08:00:00 GMT"))
+	assert.False(t, conditional("If-Modified-Since", "Mon, 29 Apr 2024 08:00:00 GMT"))
+
+	// The body changes the ETag; without upstream Last-Modified the render time is used
+	other := &renderResult{Content: []byte("<html>changed</html>"), Headers: http.Header{}, StatusCode: http.StatusOK}
+	other.addValidators(now)
+	assert.NotEqual(t, etag, other.Headers.Get("ETag"))
+	assert.Equal(t, now.Format(http.TimeFormat), other.Headers.Get("Last-Modified"))
+
+	w := httptest.NewRecorder()
+	writeNotModified(w, http.Header{"Etag": {etag}, "Content-Type": {"text/html"}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+	assert.Empty(t, w.Header().Get("Content-Type"))
+}
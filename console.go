@@ -0,0 +1,157 @@
+package headlessproxy
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+	"go.uber.org/zap"
+)
+
+// pageMessage is a console message, exception or failed load seen during a render
+type pageMessage struct {
+	Level string `json:"level"`
+	Text  string `json:"text"`
+	URL   string `json:"url,omitempty"`
+	Line  int    `json:"line,omitempty"`
+}
+
+// pageErrorCollector collects console output and page-side errors during a render
+type pageErrorCollector struct {
+	proxy     *HeadlessProxy
+	page      *rod.Page
+	requestID string
+	cancel    context.CancelFunc
+
+	mu       sync.Mutex
+	messages []pageMessage
+}
+
+// newPageErrorCollector creates a collector for the given page
+func newPageErrorCollector(proxy *HeadlessProxy, page *rod.Page, requestID string) *pageErrorCollector {
+	return &pageErrorCollector{
+		proxy:     proxy,
+		page:      page,
+		requestID: requestID,
+	}
+}
+
+// Start subscribes to the page's console, exception and log events
+func (c *pageErrorCollector) Start(ctx context.Context) {
+	ctx, c.cancel = context.WithCancel(ctx)
+
+	wait := c.page.Context(ctx).EachEvent(
+		func(e *proto.RuntimeConsoleAPICalled) {
+			args := make([]string, 0, len(e.Args))
+			for _, arg := range e.Args {
+				args = append(args, remoteObjectText(arg))
+			}
+			msg := pageMessage{
+				Level: consoleLevel(string(e.Type)),
+				Text:  strings.Join(args, " "),
+			}
+			if e.StackTrace != nil && len(e.StackTrace.CallFrames) > 0 {
+				msg.URL = e.StackTrace.CallFrames[0].URL
+				msg.Line = e.StackTrace.CallFrames[0].LineNumber + 1
+			}
+			c.add(msg)
+		},
+		func(e *proto.RuntimeExceptionThrown) {
+			details := e.ExceptionDetails
+			text := details.Text
+			if details.Exception != nil && details.Exception.Description != "" {
+				text = details.Exception.Description
+			}
+			c.add(pageMessage{
+				Level: "exception",
+				Text:  text,
+				URL:   details.URL,
+				Line:  details.LineNumber + 1,
+			})
+		},
+		func(e *proto.LogEntryAdded) {
+			// Console and exceptions are reported by the Runtime domain already
+			if e.Entry.Source != "network" {
+				return
+			}
+			c.add(pageMessage{
+				Level: "resource",
+				Text:  e.Entry.Text,
+				URL:   e.Entry.URL,
+			})
+		},
+	)
+	go wait()
+}
+
+// Stop unsubscribes from the page's events
+func (c *pageErrorCollector) Stop() {
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// add records a message, logs it and counts it by level
+func (c *pageErrorCollector) add(msg pageMessage) {
+	c.mu.Lock()
+	c.messages = append(c.messages, msg)
+	c.mu.Unlock()
+
+	c.proxy.metrics.pageMessagesTotal.WithLabelValues(msg.Level).Inc()
+
+	fields := []zap.Field{
+		zap.String("request_id", c.requestID),
+		zap.String("level", msg.Level),
+		zap.String("text", truncateString(msg.Text, 1024)),
+		zap.String("source_url", msg.URL),
+		zap.Int("line", msg.Line),
+	}
+	switch msg.Level {
+	case "exception", "error", "resource":
+		c.proxy.logger.Warn("page error", fields...)
+	case "warning":
+		c.proxy.logger.Info("page console message", fields...)
+	default:
+		c.proxy.logger.Debug("page console message", fields...)
+	}
+}
+
+// FirstError returns the first uncaught exception or console error, if any
+func (c *pageErrorCollector) FirstError() (pageMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, msg := range c.messages {
+		if msg.Level == "exception" || msg.Level == "error" {
+			return msg, true
+		}
+	}
+	return pageMessage{}, false
+}
+
+// consoleLevel maps a console API call type to a message level
+func consoleLevel(callType string) string {
+	switch callType {
+	case "error", "assert":
+		return "error"
+	case "warning":
+		return "warning"
+	case "debug", "trace":
+		return "debug"
+	default:
+		return "info"
+	}
+}
+
+// remoteObjectText renders a console argument as text
+func remoteObjectText(obj *proto.RuntimeRemoteObject) string {
+	if obj.Description != "" {
+		return obj.Description
+	}
+	if obj.UnserializableValue != "" {
+		return string(obj.UnserializableValue)
+	}
+	return obj.Value.Str()
+}
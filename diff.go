@@ -0,0 +1,279 @@
+package headlessproxy
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+	"go.uber.org/zap"
+)
+
+// diffReport describes what rendering adds on top of the raw upstream HTML
+type diffReport struct {
+	URL                string         `json:"url"`
+	RawBytes           int            `json:"raw_bytes"`
+	RenderedBytes      int            `json:"rendered_bytes"`
+	RawTextLength      int            `json:"raw_text_length"`
+	RenderedTextLength int            `json:"rendered_text_length"`
+	TextDelta          int            `json:"text_delta"`
+	TextGainRatio      float64        `json:"text_gain_ratio"`
+	RenderOnlyText     []string       `json:"render_only_text"`
+	RenderOnlyLinks    []string       `json:"render_only_links"`
+	RenderOnlyElements map[string]int `json:"render_only_elements"`
+	RawError           string         `json:"raw_error,omitempty"`
+}
+
+// diffScript compares two HTML documents parsed into detached documents, so
+// neither runs scripts and both are measured the same way.
+const diffScript = `(raw, rendered, baseURL) => {
+	const parse = (html) => new DOMParser().parseFromString(html, 'text/html');
+	const measure = (doc) => {
+		const body = (doc.body || doc.documentElement).cloneNode(true);
+		body.querySelectorAll('script, style, noscript, template').forEach(el => el.remove());
+		const texts = new Set();
+		const walker = doc.createTreeWalker(body, NodeFilter.SHOW_TEXT);
+		while (walker.nextNode()) {
+			const t = walker.currentNode.textContent.trim().replace(/\s+/g, ' ');
+			if (t) texts.add(t);
+		}
+		const links = new Set();
+		doc.querySelectorAll('a[href]').forEach(a => {
+			try { links.add(new URL(a.getAttribute('href'), baseURL).href); } catch (e) {}
+		});
+		const tags = {};
+		doc.querySelectorAll('*').forEach(el => {
+			const tag = el.tagName.toLowerCase();
+			tags[tag] = (tags[tag] || 0) + 1;
+		});
+		return { text: (body.textContent || '').replace(/\s+/g, ' ').trim(), texts, links, tags };
+	};
+	const before = measure(parse(raw));
+	const after = measure(parse(rendered));
+	const elements = {};
+	Object.keys(after.tags).forEach(tag => {
+		const extra = after.tags[tag] - (before.tags[tag] || 0);
+		if (extra > 0) elements[tag] = extra;
+	});
+	return {
+		raw_text_length: before.text.length,
+		rendered_text_length: after.text.length,
+		render_only_text: Array.from(after.texts).filter(t => !before.texts.has(t)).slice(0, 50),
+		render_only_links: Array.from(after.links).filter(l => !before.links.has(l)).slice(0, 200),
+		render_only_elements: elements
+	};
+}`
+
+// buildDiffReport compares raw and rendered HTML using the given page as a scratch document
+func buildDiffReport(page *rod.Page, targetURL, raw, rendered string) (*diffReport, error) {
+	obj, err := page.Eval(diffScript, raw, rendered, targetURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare raw and rendered HTML: %v", err)
+	}
+
+	report := &diffReport{}
+	if err := obj.Value.Unmarshal(report); err != nil {
+		return nil, fmt.Errorf("failed to decode diff report: %v", err)
+	}
+
+	report.URL = targetURL
+	report.RawBytes = len(raw)
+	report.RenderedBytes = len(rendered)
+	report.TextDelta = report.RenderedTextLength - report.RawTextLength
+	if report.RenderedTextLength > 0 {
+		report.TextGainRatio = float64(report.TextDelta) / float64(report.RenderedTextLength)
+	}
+	return report, nil
+}
+
+// renderDiff fetches the raw upstream HTML and compares it to the rendered page
+func (h *HeadlessProxy) renderDiff(ctx context.Context, r *http.Request, page *rod.Page, targetURL, rendered string) (*diffReport, error) {
+	raw, _, _, err := h.fetchUpstream(ctx, r, targetURL)
+	if err != nil {
+		// Everything is render-only if upstream can't be fetched directly
+		report, diffErr := buildDiffReport(page, targetURL, "", rendered)
+		if diffErr != nil {
+			return nil, diffErr
+		}
+		report.RawError = err.Error()
+		return report, nil
+	}
+	return buildDiffReport(page, targetURL, string(raw), rendered)
+}
+
+// DiffPathStats aggregates diff reports for a path prefix
+type DiffPathStats struct {
+	Samples               int       `json:"samples"`
+	AvgRawBytes           float64   `json:"avg_raw_bytes"`
+	AvgRenderedBytes      float64   `json:"avg_rendered_bytes"`
+	AvgTextGainRatio      float64   `json:"avg_text_gain_ratio"`
+	AvgRenderOnlyLinks    float64   `json:"avg_render_only_links"`
+	AvgRenderOnlyElements float64   `json:"avg_render_only_elements"`
+	LastSampled           time.Time `json:"last_sampled"`
+}
+
+// maxDiffPrefixes bounds the path prefixes tracked in statistics and metric
+// labels, as paths come from clients
+const maxDiffPrefixes = 100
+
+// diffOtherPrefix groups the prefixes seen after maxDiffPrefixes were tracked
+const diffOtherPrefix = "other"
+
+// diffSample is a rendered page queued for comparison against upstream
+type diffSample struct {
+	request   *http.Request
+	targetURL string
+	rendered  string
+}
+
+// DiffSampler compares a sample of rendered pages against the raw upstream
+// HTML in the background and aggregates the results per path prefix
+type DiffSampler struct {
+	proxy *HeadlessProxy
+	queue chan diffSample
+
+	mu    sync.Mutex
+	stats map[string]*DiffPathStats
+}
+
+// NewDiffSampler creates a new diff sampler
+func NewDiffSampler(proxy *HeadlessProxy) *DiffSampler {
+	return &DiffSampler{
+		proxy: proxy,
+		queue: make(chan diffSample, 16),
+		stats: make(map[string]*DiffPathStats),
+	}
+}
+
+// StartSampling starts the background comparison worker
+func (s *DiffSampler) StartSampling(ctx context.Context) {
+	go s.sampleLoop(ctx)
+}
+
+// ShouldSample decides whether the current render should be sampled
+func (s *DiffSampler) ShouldSample() bool {
+	return s.proxy.DiffSampleRate > 0 && rand.Float64() < s.proxy.DiffSampleRate
+}
+
+// Enqueue queues a rendered page for comparison, dropping it if the worker is busy
+func (s *DiffSampler) Enqueue(r *http.Request, targetURL, rendered string) {
+	sample := diffSample{
+		// Keep the render options, so upstream is fetched with the identity and locale of the render
+		request:   s.proxy.detachRequest(r, s.proxy.getRenderOptions(r)),
+		targetURL: targetURL,
+		rendered:  rendered,
+	}
+	select {
+	case s.queue <- sample:
+	default:
+	}
+}
+
+// sampleLoop compares queued samples until the context is cancelled
+func (s *DiffSampler) sampleLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sample := <-s.queue:
+			if err := s.compare(ctx, sample); err != nil {
+				s.proxy.logger.Debug("render diff sample failed",
+					zap.String("url", sample.targetURL),
+					zap.Error(err))
+			}
+		}
+	}
+}
+
+// compare diffs a single sample on a scratch page and records the result
+func (s *DiffSampler) compare(ctx context.Context, sample diffSample) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.proxy.Timeout)*time.Second)
+	defer cancel()
+
+	raw, _, _, err := s.proxy.fetchUpstream(ctx, sample.request, sample.targetURL)
+	if err != nil {
+		return err
+	}
+
+	browser := s.proxy.getBrowser()
+	if browser == nil {
+		return ErrBrowserUnavailable
+	}
+	defer s.proxy.returnBrowser(browser)
+
+	page, err := browser.Page(proto.TargetCreateTarget{URL: "about:blank"})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPageCreationFailed, err)
+	}
+	defer page.Close()
+
+	report, err := buildDiffReport(page.Context(ctx), sample.targetURL, string(raw), sample.rendered)
+	if err != nil {
+		return err
+	}
+
+	s.record(pathPrefix(sample.request.URL.Path, s.proxy.DiffPrefixDepth), report)
+	return nil
+}
+
+// record folds a report into the running averages of its path prefix. Past
+// maxDiffPrefixes tracked prefixes, new ones are grouped under "other".
+func (s *DiffSampler) record(prefix string, report *diffReport) {
+	elements := 0
+	for _, n := range report.RenderOnlyElements {
+		elements += n
+	}
+
+	s.mu.Lock()
+	stats, ok := s.stats[prefix]
+	if !ok && len(s.stats) >= maxDiffPrefixes {
+		prefix = diffOtherPrefix
+		stats, ok = s.stats[prefix]
+	}
+	if !ok {
+		stats = &DiffPathStats{}
+		s.stats[prefix] = stats
+	}
+	stats.Samples++
+	n := float64(stats.Samples)
+	stats.AvgRawBytes += (float64(report.RawBytes) - stats.AvgRawBytes) / n
+	stats.AvgRenderedBytes += (float64(report.RenderedBytes) - stats.AvgRenderedBytes) / n
+	stats.AvgTextGainRatio += (report.TextGainRatio - stats.AvgTextGainRatio) / n
+	stats.AvgRenderOnlyLinks += (float64(len(report.RenderOnlyLinks)) - stats.AvgRenderOnlyLinks) / n
+	stats.AvgRenderOnlyElements += (float64(elements) - stats.AvgRenderOnlyElements) / n
+	stats.LastSampled = time.Now()
+	gain := stats.AvgTextGainRatio
+	s.mu.Unlock()
+
+	s.proxy.metrics.renderDiffSamples.WithLabelValues(prefix).Inc()
+	s.proxy.metrics.renderDiffTextGain.WithLabelValues(prefix).Set(gain)
+}
+
+// Stats returns a snapshot of the per-prefix statistics
+func (s *DiffSampler) Stats() map[string]DiffPathStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make(map[string]DiffPathStats, len(s.stats))
+	for prefix, stats := range s.stats {
+		result[prefix] = *stats
+	}
+	return result
+}
+
+// pathPrefix returns the first depth segments of a path
+func pathPrefix(path string, depth int) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	if depth <= 0 || segments[0] == "" {
+		return "/"
+	}
+	if len(segments) > depth {
+		segments = segments[:depth]
+	}
+	return "/" + strings.Join(segments, "/")
+}
@@ -0,0 +1,59 @@
+package headlessproxy
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathPrefix(t *testing.T) {
+	tests := []struct {
+		path   string
+		depth  int
+		prefix string
+	}{
+		{"/blog/2024/hello", 1, "/blog"},
+		{"/blog/2024/hello", 2, "/blog/2024"},
+		{"/blog/2024/hello", 5, "/blog/2024/hello"},
+		{"/blog/", 1, "/blog"},
+		{"blog/post", 1, "/blog"},
+		{"/", 1, "/"},
+		{"", 2, "/"},
+		// Without a depth everything shares one prefix
+		{"/blog/2024/hello", 0, "/"},
+		{"/blog/2024/hello", -1, "/"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.prefix, pathPrefix(tt.path, tt.depth), "%s at depth %d", tt.path, tt.depth)
+	}
+}
+
+func TestDiffSamplerPrefixOverflow(t *testing.T) {
+	h := &HeadlessProxy{}
+	h.metrics.renderDiffSamples = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_render_diff_samples_total"}, []string{"prefix"})
+	h.metrics.renderDiffTextGain = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_render_diff_text_gain"}, []string{"prefix"})
+	s := NewDiffSampler(h)
+
+	for i := 0; i < maxDiffPrefixes; i++ {
+		s.record(fmt.Sprintf("/section-%d", i), &diffReport{RawBytes: 100})
+	}
+	assert.Len(t, s.Stats(), maxDiffPrefixes)
+
+	// Prefixes already tracked keep their own statistics
+	s.record("/section-0", &diffReport{RawBytes: 300})
+	assert.Equal(t, 2, s.Stats()["/section-0"].Samples)
+	assert.InDelta(t, 200, s.Stats()["/section-0"].AvgRawBytes, 0.001)
+
+	// New prefixes are grouped together instead of growing the map
+	s.record("/new-a", &diffReport{RawBytes: 100, TextGainRatio: 0.5})
+	s.record("/new-b", &diffReport{RawBytes: 300, TextGainRatio: 0.1})
+	stats := s.Stats()
+	assert.Len(t, stats, maxDiffPrefixes+1)
+	assert.NotContains(t, stats, "/new-a")
+	assert.NotContains(t, stats, "/new-b")
+	assert.Equal(t, 2, stats[diffOtherPrefix].Samples)
+	assert.InDelta(t, 200, stats[diffOtherPrefix].AvgRawBytes, 0.001)
+	assert.InDelta(t, 0.3, stats[diffOtherPrefix].AvgTextGainRatio, 0.001)
+}
@@ -0,0 +1,476 @@
+package headlessproxy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+)
+
+// cacheFileSuffix marks cache files among other keys of a store
+const cacheFileSuffix = ".cache"
+
+// cacheStore holds the files of a disk cache
+type cacheStore interface {
+	// Write stores a file atomically, so readers never see partial content
+	Write(name string, data []byte) error
+
+	// Read returns the content of a file
+	Read(name string) ([]byte, error)
+
+	// Remove deletes a file
+	Remove(name string) error
+
+	// List returns all cache files
+	List() ([]storedFile, error)
+}
+
+// storedFile describes a file of a cache store
+type storedFile struct {
+	name     string
+	size     int64
+	modified time.Time
+}
+
+// diskCache is a CacheBackend keeping gzip-compressed responses in a
+// cacheStore. An in-memory index of the stored entries, rebuilt from the
+// store at startup, tracks their size and recency to stay within its limit.
+type diskCache struct {
+	store    cacheStore
+	maxBytes int64
+	logger   *zap.Logger
+
+	// keyLocks serialize writing and removing the file of a key with its
+	// index update, so a file is never removed after being written again
+	keyLocks [64]sync.Mutex
+
+	mu        sync.Mutex
+	entries   map[string]*list.Element
+	order     *list.List
+	bytes     int64
+	evictions int64
+}
+
+// newDiskCache creates a disk cache holding up to maxBytes of files and
+// indexes the entries already in store
+func newDiskCache(store cacheStore, maxBytes int64, logger *zap.Logger) (*diskCache, error) {
+	c := &diskCache{
+		store:    store,
+		maxBytes: maxBytes,
+		logger:   logger,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+
+	files, err := store.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cache files: %v", err)
+	}
+
+	// Oldest files go to the back of the recency list
+	sort.Slice(files, func(i, j int) bool { return files[i].modified.Before(files[j].modified) })
+	for _, file := range files {
+		key := strings.TrimSuffix(path.Base(file.name), cacheFileSuffix)
+		data, err := store.Read(file.name)
+		if err == nil {
+			var entry *cacheEntry
+			entry, err = decodeCacheEntry(data, false)
+			if err == nil {
+				entry.hits = new(atomic.Int64)
+				c.entries[key] = c.order.PushFront(&lruItem{key: key, entry: entry, size: file.size})
+				c.bytes += file.size
+				continue
+			}
+		}
+		logger.Warn("removing unreadable cache file", zap.String("file", file.name), zap.Error(err))
+		_ = store.Remove(file.name)
+	}
+
+	c.evict()
+	logger.Info("disk cache index loaded",
+		zap.Int("entries", len(c.entries)),
+		zap.Int64("bytes", c.bytes))
+	return c, nil
+}
+
+// cacheFileName spreads cache files over directories by the first two characters of the key
+func cacheFileName(key string) string {
+	if len(key) < 2 {
+		return key + cacheFileSuffix
+	}
+	return key[:2] + "/" + key + cacheFileSuffix
+}
+
+// keyLock returns the lock guarding the file of a key
+func (c *diskCache) keyLock(key string) *sync.Mutex {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return &c.keyLocks[hash.Sum32()%uint32(len(c.keyLocks))]
+}
+
+// Get reads an entry from the store and marks it as recently used
+func (c *diskCache) Get(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	elem, ok := c.entries[key]
+	var hits *atomic.Int64
+	if ok {
+		c.order.MoveToFront(elem)
+		hits = elem.Value.(*lruItem).entry.hits
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	data, err := c.store.Read(cacheFileName(key))
+	if err == nil {
+		var entry *cacheEntry
+		entry, err = decodeCacheEntry(data, true)
+		if err == nil {
+			// Hits are counted in the index, which outlives the decoded copies
+			entry.hits = hits
+			return entry, true
+		}
+	}
+
+	// The file may have been evicted since the lookup
+	if !errors.Is(err, fs.ErrNotExist) {
+		c.logger.Warn("failed to read cache file", zap.String("key", key), zap.Error(err))
+	}
+
+	// Only the entry that was looked up is dropped, not one set meanwhile
+	c.mu.Lock()
+	if current, ok := c.entries[key]; ok && current == elem {
+		c.unindex(elem)
+	}
+	c.mu.Unlock()
+	c.remove(key)
+	return nil, false
+}
+
+// Set compresses an entry and writes it to the store, evicting the least
+// recently used entries to make room
+func (c *diskCache) Set(key string, entry *cacheEntry) error {
+	data, err := encodeCacheEntry(entry)
+	if err != nil {
+		return err
+	}
+	size := int64(len(data))
+	if size > c.maxBytes {
+		return nil
+	}
+
+	// The index keeps the metadata only
+	meta := *entry
+	meta.Content = nil
+	meta.hits = new(atomic.Int64)
+
+	lock := c.keyLock(key)
+	lock.Lock()
+	if err := c.store.Write(cacheFileName(key), data); err != nil {
+		lock.Unlock()
+		return fmt.Errorf("failed to write cache file: %v", err)
+	}
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.unindex(elem)
+	}
+	c.entries[key] = c.order.PushFront(&lruItem{key: key, entry: &meta, size: size})
+	c.bytes += size
+	c.mu.Unlock()
+	lock.Unlock()
+
+	c.evict()
+	return nil
+}
+
+// Delete removes an entry
+func (c *diskCache) Delete(key string) bool {
+	c.mu.Lock()
+	elem, ok := c.entries[key]
+	if ok {
+		c.unindex(elem)
+	}
+	c.mu.Unlock()
+
+	if ok {
+		c.remove(key)
+	}
+	return ok
+}
+
+// Purge removes the entries match selects, or all entries if match is nil.
+// The entries passed to match carry their metadata but not their content.
+func (c *diskCache) Purge(match func(key string, entry *cacheEntry) bool) int {
+	var keys []string
+	c.mu.Lock()
+	for key, elem := range c.entries {
+		if match == nil || match(key, elem.Value.(*lruItem).entry) {
+			c.unindex(elem)
+			keys = append(keys, key)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, key := range keys {
+		c.remove(key)
+	}
+	return len(keys)
+}
+
+// Stats reports the number of entries, their size on disk and the evictions so far
+func (c *diskCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Entries:   len(c.entries),
+		Bytes:     c.bytes,
+		Evictions: c.evictions,
+	}
+}
+
+// evict removes the least recently used entries until the cache fits its limit
+func (c *diskCache) evict() {
+	var keys []string
+	c.mu.Lock()
+	for c.bytes > c.maxBytes {
+		elem := c.order.Back()
+		c.unindex(elem)
+		keys = append(keys, elem.Value.(*lruItem).key)
+		c.evictions++
+	}
+	c.mu.Unlock()
+
+	for _, key := range keys {
+		c.remove(key)
+	}
+}
+
+// unindex drops an element from the index; the lock must be held
+func (c *diskCache) unindex(elem *list.Element) {
+	item := elem.Value.(*lruItem)
+	c.order.Remove(elem)
+	delete(c.entries, item.key)
+	c.bytes -= item.size
+}
+
+// remove deletes the file of an entry dropped from the index, unless the key
+// was set again meanwhile
+func (c *diskCache) remove(key string) {
+	lock := c.keyLock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	c.mu.Lock()
+	_, indexed := c.entries[key]
+	c.mu.Unlock()
+	if indexed {
+		return
+	}
+
+	err := c.store.Remove(cacheFileName(key))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		c.logger.Warn("failed to remove cache file", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// encodeCacheEntry serializes an entry as a line of JSON metadata followed by the gzip-compressed content
+func encodeCacheEntry(entry *cacheEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(entry); err != nil {
+		return nil, fmt.Errorf("failed to encode cache entry: %v", err)
+	}
+
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(entry.Content); err != nil {
+		return nil, fmt.Errorf("failed to compress cache entry: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress cache entry: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeCacheEntry parses an encoded entry, decompressing its content if withContent is set
+func decodeCacheEntry(data []byte, withContent bool) (*cacheEntry, error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+	meta, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("truncated cache entry: %v", err)
+	}
+
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(meta, entry); err != nil {
+		return nil, fmt.Errorf("invalid cache entry: %v", err)
+	}
+	if !withContent {
+		return entry, nil
+	}
+
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache entry content: %v", err)
+	}
+	entry.Content, err = io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache entry content: %v", err)
+	}
+	return entry, nil
+}
+
+// dirStore is a cacheStore in a local directory
+type dirStore struct {
+	dir string
+}
+
+// newDirStore creates the directory if needed and clears files left over by interrupted writes
+func newDirStore(dir string) (*dirStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %v", err)
+	}
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasPrefix(d.Name(), ".tmp-") {
+			_ = os.Remove(p)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to clean cache directory: %v", err)
+	}
+	return &dirStore{dir: dir}, nil
+}
+
+// Write writes a temporary file, syncs it and renames it into place, then
+// syncs the directory so the rename survives a crash
+func (s *dirStore) Write(name string, data []byte) error {
+	target := filepath.Join(s.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// Read returns the content of a file
+func (s *dirStore) Read(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.dir, filepath.FromSlash(name)))
+}
+
+// Remove deletes a file
+func (s *dirStore) Remove(name string) error {
+	return os.Remove(filepath.Join(s.dir, filepath.FromSlash(name)))
+}
+
+// List returns all cache files in the directory
+func (s *dirStore) List() ([]storedFile, error) {
+	var files []storedFile
+	err := filepath.WalkDir(s.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(d.Name(), cacheFileSuffix) {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, storedFile{name: filepath.ToSlash(rel), size: info.Size(), modified: info.ModTime()})
+		return nil
+	})
+	return files, err
+}
+
+// storageStore is a cacheStore in Caddy's configured storage, under a key prefix
+type storageStore struct {
+	storage certmagic.Storage
+	prefix  string
+}
+
+// Write stores a file; atomicity is up to the storage module
+func (s *storageStore) Write(name string, data []byte) error {
+	return s.storage.Store(context.Background(), path.Join(s.prefix, name), data)
+}
+
+// Read returns the content of a file
+func (s *storageStore) Read(name string) ([]byte, error) {
+	return s.storage.Load(context.Background(), path.Join(s.prefix, name))
+}
+
+// Remove deletes a file
+func (s *storageStore) Remove(name string) error {
+	return s.storage.Delete(context.Background(), path.Join(s.prefix, name))
+}
+
+// List returns all cache files under the prefix
+func (s *storageStore) List() ([]storedFile, error) {
+	ctx := context.Background()
+	keys, err := s.storage.List(ctx, s.prefix, true)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]storedFile, 0, len(keys))
+	for _, key := range keys {
+		if !strings.HasSuffix(key, cacheFileSuffix) {
+			continue
+		}
+		info, err := s.storage.Stat(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(key, s.prefix), "/")
+		files = append(files, storedFile{name: name, size: info.Size, modified: info.Modified})
+	}
+	return files, nil
+}
@@ -0,0 +1,113 @@
+package headlessproxy
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newDirStore(dir)
+	require.NoError(t, err)
+	cache, err := newDiskCache(store, 1<<20, zap.NewNop())
+	require.NoError(t, err)
+
+	entry := &cacheEntry{
+		Content:    []byte("<html><body>rendered</body></html>"),
+		Headers:    http.Header{"Content-Type": {"text/html"}},
+		StatusCode: http.StatusOK,
+		Expires:    time.Now().Add(time.Hour).Round(0),
+	}
+	require.NoError(t, cache.Set("0123abcd", entry))
+
+	got, ok := cache.Get("0123abcd")
+	require.True(t, ok)
+	assert.Equal(t, entry.Content, got.Content)
+	assert.Equal(t, entry.Headers, got.Headers)
+	assert.True(t, entry.Expires.Equal(got.Expires))
+
+	// A file left over by an interrupted write is cleared at startup
+	leftover := filepath.Join(dir, "01", ".tmp-123")
+	require.NoError(t, os.WriteFile(leftover, []byte("partial"), 0o644))
+
+	// The index is rebuilt from the directory
+	store, err = newDirStore(dir)
+	require.NoError(t, err)
+	restarted, err := newDiskCache(store, 1<<20, zap.NewNop())
+	require.NoError(t, err)
+	assert.Equal(t, cache.Stats(), restarted.Stats())
+	got, ok = restarted.Get("0123abcd")
+	require.True(t, ok)
+	assert.Equal(t, entry.Content, got.Content)
+	_, err = os.Stat(leftover)
+	assert.True(t, os.IsNotExist(err))
+
+	// Entries beyond the size limit evict the least recently used ones
+	size := restarted.Stats().Bytes
+	small, err := newDiskCache(store, size*2, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, small.Set("4567abcd", entry))
+	require.NoError(t, small.Set("89efabcd", entry))
+	_, ok = small.Get("0123abcd")
+	assert.False(t, ok)
+	assert.Equal(t, int64(1), small.Stats().Evictions)
+	_, err = os.Stat(filepath.Join(dir, "01", "0123abcd.cache"))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Equal(t, 2, small.Purge(nil))
+	assert.Equal(t, CacheStats{Evictions: 1}, small.Stats())
+}
+
+func TestDiskCacheConcurrent(t *testing.T) {
+	store, err := newDirStore(t.TempDir())
+	require.NoError(t, err)
+	entry := &cacheEntry{Content: []byte("<html><body>rendered</body></html>")}
+	data, err := encodeCacheEntry(entry)
+	require.NoError(t, err)
+
+	// Room for a few entries, so sets keep evicting
+	cache, err := newDiskCache(store, int64(len(data))*3, zap.NewNop())
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 60; j++ {
+				key := fmt.Sprintf("%02x", (i+j)%6)
+				switch j % 3 {
+				case 0, 1:
+					assert.NoError(t, cache.Set(key, entry))
+				default:
+					cache.Delete(key)
+				}
+				cache.Get(key)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Every indexed entry has its file and no file is left without an entry
+	files, err := store.List()
+	require.NoError(t, err)
+	assert.Equal(t, cache.Stats().Entries, len(files))
+	for _, file := range files {
+		key := strings.TrimSuffix(path.Base(file.name), cacheFileSuffix)
+		got, ok := cache.Get(key)
+		if assert.True(t, ok, key) {
+			assert.Equal(t, entry.Content, got.Content)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package headlessproxy
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// documentRecorder records the upstream response of a page's main document,
+// whose caching headers the rendered response inherits
+type documentRecorder struct {
+	page   *rod.Page
+	cancel context.CancelFunc
+
+	mu         sync.Mutex
+	statusCode int
+	headers    http.Header
+}
+
+// newDocumentRecorder creates a recorder for the given page
+func newDocumentRecorder(page *rod.Page) *documentRecorder {
+	return &documentRecorder{page: page}
+}
+
+// Start subscribes to the page's network responses
+func (d *documentRecorder) Start(ctx context.Context) {
+	ctx, d.cancel = context.WithCancel(ctx)
+
+	wait := d.page.Context(ctx).EachEvent(func(e *proto.NetworkResponseReceived) {
+		// Redirects are reported separately, so the last document response is the final one
+		if e.Type != proto.NetworkResourceTypeDocument || e.FrameID != d.page.FrameID {
+			return
+		}
+
+		headers := make(http.Header)
+		for name, value := range e.Response.Headers {
+			// CDP joins repeated headers with newlines
+			for _, v := range strings.Split(value.Str(), "\n") {
+				headers.Add(name, v)
+			}
+		}
+
+		d.mu.Lock()
+		d.statusCode = e.Response.Status
+		d.headers = headers
+		d.mu.Unlock()
+	})
+	go wait()
+}
+
+// Stop unsubscribes from the page's events
+func (d *documentRecorder) Stop() {
+	if d.cancel != nil {
+		d.cancel()
+	}
+}
+
+// Response returns the status and headers of the main document, if one was received
+func (d *documentRecorder) Response() (int, http.Header) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.statusCode, d.headers
+}
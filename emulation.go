@@ -0,0 +1,159 @@
+package headlessproxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// EmulationProfile describes a device emulated while rendering
+type EmulationProfile struct {
+	// Viewport size in CSS pixels
+	Width  int `json:"width"`
+	Height int `json:"height"`
+
+	// Device pixel ratio (0 keeps the browser default)
+	DeviceScaleFactor float64 `json:"device_scale_factor,omitempty"`
+
+	// Whether to emulate a mobile device (meta viewport, overlay scrollbars)
+	Mobile bool `json:"mobile,omitempty"`
+
+	// Whether to emulate a touch screen
+	Touch bool `json:"touch,omitempty"`
+
+	// User-Agent sent by the device (empty keeps the configured one)
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// builtinProfiles are the emulation profiles available without configuration
+var builtinProfiles = map[string]EmulationProfile{
+	"iphone-14": {
+		Width: 390, Height: 844, DeviceScaleFactor: 3, Mobile: true, Touch: true,
+		UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1",
+	},
+	"iphone-se": {
+		Width: 375, Height: 667, DeviceScaleFactor: 2, Mobile: true, Touch: true,
+		UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1",
+	},
+	"pixel-7": {
+		Width: 412, Height: 915, DeviceScaleFactor: 2.625, Mobile: true, Touch: true,
+		UserAgent: "Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+	},
+	"galaxy-s23": {
+		Width: 360, Height: 780, DeviceScaleFactor: 3, Mobile: true, Touch: true,
+		UserAgent: "Mozilla/5.0 (Linux; Android 13; SM-S911B) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+	},
+	"ipad-air": {
+		Width: 820, Height: 1180, DeviceScaleFactor: 2, Mobile: true, Touch: true,
+		UserAgent: "Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1",
+	},
+	"galaxy-tab-s8": {
+		Width: 800, Height: 1280, DeviceScaleFactor: 2, Mobile: true, Touch: true,
+		UserAgent: "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+	},
+	"laptop": {
+		Width: 1440, Height: 900, DeviceScaleFactor: 2,
+	},
+	"desktop": {
+		Width: 1366, Height: 768, DeviceScaleFactor: 1,
+	},
+	"desktop-hd": {
+		Width: 1920, Height: 1080, DeviceScaleFactor: 1,
+	},
+}
+
+// lookupProfile finds a configured or built-in profile by name
+func (h *HeadlessProxy) lookupProfile(name string) (EmulationProfile, bool) {
+	if profile, ok := h.Profiles[name]; ok {
+		return profile, true
+	}
+	profile, ok := builtinProfiles[name]
+	return profile, ok
+}
+
+// selectProfile picks the emulation profile for a request: an explicit header
+// wins, then a match on the client's User-Agent and Client Hints, then the default.
+func (h *HeadlessProxy) selectProfile(r *http.Request) (string, *EmulationProfile) {
+	if h.ProfileHeader != "" {
+		if name := strings.TrimSpace(r.Header.Get(h.ProfileHeader)); name != "" {
+			if profile, ok := h.lookupProfile(name); ok {
+				return name, &profile
+			}
+		}
+	}
+
+	if h.ProfileMatch {
+		if name := matchProfile(r); name != "" {
+			if profile, ok := h.lookupProfile(name); ok {
+				return name, &profile
+			}
+		}
+	}
+
+	if h.DefaultProfile != "" {
+		if profile, ok := h.lookupProfile(h.DefaultProfile); ok {
+			return h.DefaultProfile, &profile
+		}
+	}
+
+	return "", nil
+}
+
+// matchProfile maps the client's User-Agent and Client Hints to a built-in
+// profile name, or "" for clients that are neither mobile nor a desktop browser
+func matchProfile(r *http.Request) string {
+	ua := r.Header.Get("User-Agent")
+	switch {
+	case strings.Contains(ua, "iPad"):
+		return "ipad-air"
+	case strings.Contains(ua, "iPhone") || strings.Contains(ua, "iPod"):
+		return "iphone-14"
+	case strings.Contains(ua, "Android") && strings.Contains(ua, "Mobile"):
+		return "pixel-7"
+	case strings.Contains(ua, "Android"):
+		return "galaxy-tab-s8"
+	case r.Header.Get("Sec-CH-UA-Mobile") == "?1":
+		return "pixel-7"
+	case strings.Contains(ua, "Mobile"):
+		return "pixel-7"
+	case strings.Contains(ua, "Windows NT") || strings.Contains(ua, "Macintosh") ||
+		strings.Contains(ua, "X11") || strings.Contains(ua, "CrOS"):
+		return "desktop"
+	default:
+		return ""
+	}
+}
+
+// renderUserAgent returns the User-Agent the browser presents for a request
+func (h *HeadlessProxy) renderUserAgent(opts *renderOptions) string {
+	if opts.UserAgent != "" {
+		return opts.UserAgent
+	}
+	if opts.Device != nil && opts.Device.UserAgent != "" {
+		return opts.Device.UserAgent
+	}
+	return h.defaultUserAgent()
+}
+
+// applyProfile emulates a device on a page before navigation
+func applyProfile(page *rod.Page, profile *EmulationProfile) error {
+	err := proto.EmulationSetDeviceMetricsOverride{
+		Width:             profile.Width,
+		Height:            profile.Height,
+		DeviceScaleFactor: profile.DeviceScaleFactor,
+		Mobile:            profile.Mobile,
+	}.Call(page)
+	if err != nil {
+		return fmt.Errorf("failed to set device metrics: %v", err)
+	}
+
+	err = proto.EmulationSetTouchEmulationEnabled{Enabled: profile.Touch}.Call(page)
+	if err != nil {
+		return fmt.Errorf("failed to set touch emulation: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package headlessproxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchProfile(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		mobile    string
+		profile   string
+	}{
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 16_6 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148 Safari/604.1", "", "iphone-14"},
+		{"Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148 Safari/604.1", "", "ipad-air"},
+		{"Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36 Chrome/120.0.0.0 Mobile Safari/537.36", "?1", "pixel-7"},
+		{"Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 Chrome/120.0.0.0 Safari/537.36", "?0", "galaxy-tab-s8"},
+		{"Mozilla/5.0 (Linux; K) AppleWebKit/537.36 Chrome/120.0.0.0 Safari/537.36", "?1", "pixel-7"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/120.0.0.0 Safari/537.36", "?0", "desktop"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 Version/17.0 Safari/605.1.15", "", "desktop"},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0", "", "desktop"},
+		// Nothing specific matches crawlers, tools or a missing User-Agent
+		{"Googlebot/2.1 (+http://www.google.com/bot.html)", "", ""},
+		{"curl/8.4.0", "", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("User-Agent", tt.userAgent)
+		if tt.mobile != "" {
+			r.Header.Set("Sec-CH-UA-Mobile", tt.mobile)
+		}
+		assert.Equal(t, tt.profile, matchProfile(r), tt.userAgent)
+	}
+}
+
+func TestSelectProfile(t *testing.T) {
+	h := &HeadlessProxy{
+		ProfileHeader:  "X-Device",
+		ProfileMatch:   true,
+		DefaultProfile: "laptop",
+		Profiles: map[string]EmulationProfile{
+			"kiosk": {Width: 1080, Height: 1920},
+		},
+	}
+	request := func(userAgent, device string) string {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("User-Agent", userAgent)
+		if device != "" {
+			r.Header.Set("X-Device", device)
+		}
+		name, _ := h.selectProfile(r)
+		return name
+	}
+
+	// The header wins, unless it names an unknown profile
+	assert.Equal(t, "kiosk", request("Mozilla/5.0 (iPhone; CPU iPhone OS 16_6 like Mac OS X)", "kiosk"))
+	assert.Equal(t, "iphone-14", request("Mozilla/5.0 (iPhone; CPU iPhone OS 16_6 like Mac OS X)", "unknown"))
+
+	// The default applies when nothing specific matches
+	assert.Equal(t, "desktop", request("Mozilla/5.0 (Windows NT 10.0; Win64; x64)", ""))
+	assert.Equal(t, "laptop", request("Googlebot/2.1", ""))
+
+	h.DefaultProfile = ""
+	name, profile := h.selectProfile(httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "", name)
+	assert.Nil(t, profile)
+
+	// Without matching, only the header and the default select a profile
+	h.ProfileMatch = false
+	h.DefaultProfile = "laptop"
+	assert.Equal(t, "laptop", request("Mozilla/5.0 (iPhone; CPU iPhone OS 16_6 like Mac OS X)", ""))
+}
@@ -2,6 +2,7 @@ package headlessproxy
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
 	"go.uber.org/zap"
 )
 
@@ -20,6 +22,10 @@ var (
 	ErrTimeout            = errors.New("operation timed out")
 	ErrRequestFailed      = errors.New("request failed")
 	ErrResponseProcessing = errors.New("response processing failed")
+	ErrJSError            = errors.New("javascript error during render")
+	ErrNotAcceptable      = errors.New("no acceptable output format")
+	ErrOverrideSignature  = errors.New("invalid override signature")
+	ErrInvalidOverride    = errors.New("invalid render override")
 )
 
 // ErrorResponse represents an error response
@@ -33,11 +39,7 @@ type ErrorResponse struct {
 
 // handleError handles an error and returns an appropriate HTTP response
 func (h *HeadlessProxy) handleError(w http.ResponseWriter, r *http.Request, err error, status int) {
-	// Generate a request ID if not present
-	requestID := r.Header.Get("X-Request-ID")
-	if requestID == "" {
-		requestID = fmt.Sprintf("%d", time.Now().UnixNano())
-	}
+	requestID := getRequestID(r)
 
 	// Log the error
 	h.logger.Error("request error",
@@ -63,6 +65,14 @@ func (h *HeadlessProxy) handleError(w http.ResponseWriter, r *http.Request, err
 		errorType = "request_failed"
 	case errors.Is(err, ErrResponseProcessing):
 		errorType = "response_processing"
+	case errors.Is(err, ErrJSError):
+		errorType = "js_error"
+	case errors.Is(err, ErrNotAcceptable):
+		errorType = "not_acceptable"
+	case errors.Is(err, ErrOverrideSignature):
+		errorType = "override_signature"
+	case errors.Is(err, ErrInvalidOverride):
+		errorType = "invalid_override"
 	case errors.Is(err, context.DeadlineExceeded):
 		errorType = "deadline_exceeded"
 		err = ErrTimeout
@@ -90,6 +100,16 @@ func (h *HeadlessProxy) handleError(w http.ResponseWriter, r *http.Request, err
 	}
 }
 
+// renderErrorStatus returns the status of the error response for a failed
+// render; other errors are left to Caddy
+func renderErrorStatus(err error) (int, bool) {
+	if errors.Is(err, ErrJSError) {
+		// The page loaded but broke while rendering, like a failing upstream
+		return http.StatusBadGateway, true
+	}
+	return 0, false
+}
+
 // handleBrowserError handles browser-specific errors
 func (h *HeadlessProxy) handleBrowserError(browser *rod.Browser, err error) error {
 	if err == nil {
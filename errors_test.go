@@ -0,0 +1,59 @@
+package headlessproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRenderErrorResponse(t *testing.T) {
+	h := &HeadlessProxy{logger: zap.NewNop()}
+	h.metrics.browserErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_browser_errors_total"}, []string{"type"})
+
+	// A render failed by fail_on_js_error gets its own error response
+	err := fmt.Errorf("%w: %s", ErrJSError, "Uncaught TypeError: x is undefined")
+	status, ok := renderErrorStatus(err)
+	require.True(t, ok)
+	assert.Equal(t, http.StatusBadGateway, status)
+
+	r := withRequestID(httptest.NewRequest("GET", "/page", nil))
+	w := httptest.NewRecorder()
+	h.handleError(w, r, err, status)
+
+	var body ErrorResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&body))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, "js_error", w.Header().Get("X-Error-Type"))
+	assert.Equal(t, "js_error", body.Error)
+	assert.Equal(t, err.Error(), body.Description)
+
+	// The response names the ID the render logged its page errors with
+	assert.Equal(t, getRequestID(r), body.RequestID)
+	assert.Equal(t, getRequestID(r), w.Header().Get("X-Request-ID"))
+
+	// Other failures are left to Caddy
+	_, ok = renderErrorStatus(fmt.Errorf("%w: net::ERR_FAILED", ErrNavigationFailed))
+	assert.False(t, ok)
+}
+
+func TestRequestID(t *testing.T) {
+	// A generated ID stays the same for the whole request
+	r := withRequestID(httptest.NewRequest("GET", "/", nil))
+	id := getRequestID(r)
+	assert.NotEmpty(t, id)
+	assert.Equal(t, id, getRequestID(r))
+	assert.Equal(t, id, getRequestID(withRequestID(r)))
+	assert.Empty(t, r.Header.Get("X-Request-ID"))
+
+	// The client's ID is kept
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Request-ID", "abc")
+	assert.Equal(t, "abc", getRequestID(withRequestID(r)))
+}
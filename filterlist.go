@@ -0,0 +1,587 @@
+package headlessproxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-rod/rod/lib/proto"
+	"go.uber.org/zap"
+	"golang.org/x/net/publicsuffix"
+)
+
+// filterOptionTypes maps filter list type options to the resource types they cover
+var filterOptionTypes = map[string][]proto.NetworkResourceType{
+	"script":         {proto.NetworkResourceTypeScript},
+	"image":          {proto.NetworkResourceTypeImage},
+	"stylesheet":     {proto.NetworkResourceTypeStylesheet},
+	"css":            {proto.NetworkResourceTypeStylesheet},
+	"font":           {proto.NetworkResourceTypeFont},
+	"media":          {proto.NetworkResourceTypeMedia},
+	"xmlhttprequest": {proto.NetworkResourceTypeXHR, proto.NetworkResourceTypeFetch},
+	"xhr":            {proto.NetworkResourceTypeXHR, proto.NetworkResourceTypeFetch},
+	"subdocument":    {proto.NetworkResourceTypeDocument},
+	"frame":          {proto.NetworkResourceTypeDocument},
+	"websocket":      {proto.NetworkResourceTypeWebSocket},
+	"ping":           {proto.NetworkResourceTypePing},
+	"object":         {proto.NetworkResourceTypeOther},
+	"other":          {proto.NetworkResourceTypeOther},
+}
+
+// commonTokens are too frequent in URLs to be useful as index keys
+var commonTokens = map[string]bool{
+	"http": true, "https": true, "www": true, "com": true, "net": true, "org": true,
+	"js": true, "html": true, "php": true,
+}
+
+// networkFilter is a single compiled network filter
+type networkFilter struct {
+	raw string
+
+	// Pattern with anchors removed and wildcards added, or a regular expression
+	glob  string
+	regex *regexp.Regexp
+
+	// Whether the pattern starts at a hostname label boundary (||)
+	hostAnchor bool
+
+	exception bool
+	important bool
+
+	// Resource types the filter applies to (nil is all)
+	types map[proto.NetworkResourceType]bool
+
+	// 1 for third-party requests only, -1 for first-party only
+	thirdParty int
+
+	// Page domains the filter is limited to or excluded from
+	domains    []string
+	notDomains []string
+}
+
+// filterRequest is a request being checked against filters
+type filterRequest struct {
+	url          string
+	host         string
+	hostStarts   []int
+	pageHost     string
+	resourceType proto.NetworkResourceType
+	thirdParty   bool
+}
+
+// parseFilter compiles a network filter line. It returns false for comments,
+// cosmetic filters and filters using options that can't be honored here.
+func parseFilter(line string) (*networkFilter, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '!' || line[0] == '[' {
+		return nil, false
+	}
+	for _, marker := range []string{"##", "#@#", "#?#", "#$#", "#%#"} {
+		if strings.Contains(line, marker) {
+			return nil, false
+		}
+	}
+
+	f := &networkFilter{raw: line}
+	if strings.HasPrefix(line, "@@") {
+		f.exception = true
+		line = line[2:]
+	}
+
+	// Options follow the last $, unless it belongs to a regular expression
+	if i := strings.LastIndex(line, "$"); i >= 0 && !strings.Contains(line[i+1:], "/") {
+		if !f.parseOptions(line[i+1:]) {
+			return nil, false
+		}
+		line = line[:i]
+	}
+
+	if len(line) > 2 && line[0] == '/' && line[len(line)-1] == '/' {
+		regex, err := regexp.Compile("(?i)" + line[1:len(line)-1])
+		if err != nil {
+			return nil, false
+		}
+		f.regex = regex
+		return f, true
+	}
+
+	startAnchor, endAnchor := false, false
+	switch {
+	case strings.HasPrefix(line, "||"):
+		f.hostAnchor = true
+		line = line[2:]
+	case strings.HasPrefix(line, "|"):
+		startAnchor = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "|") {
+		endAnchor = true
+		line = line[:len(line)-1]
+	}
+
+	pattern := strings.ToLower(line)
+	for strings.Contains(pattern, "**") {
+		pattern = strings.ReplaceAll(pattern, "**", "*")
+	}
+	// A filter matching every URL must be narrowed down by its options
+	if strings.Trim(pattern, "*") == "" && f.types == nil && len(f.domains) == 0 {
+		return nil, false
+	}
+
+	f.glob = pattern
+	if !f.hostAnchor && !startAnchor && !strings.HasPrefix(f.glob, "*") {
+		f.glob = "*" + f.glob
+	}
+	if !endAnchor && !strings.HasSuffix(f.glob, "*") {
+		f.glob += "*"
+	}
+	return f, true
+}
+
+// parseOptions applies the comma-separated options of a filter
+func (f *networkFilter) parseOptions(options string) bool {
+	include := map[proto.NetworkResourceType]bool{}
+	exclude := map[proto.NetworkResourceType]bool{}
+
+	for _, option := range strings.Split(strings.ToLower(options), ",") {
+		negated := strings.HasPrefix(option, "~")
+		name := strings.TrimPrefix(option, "~")
+
+		if types, ok := filterOptionTypes[name]; ok {
+			for _, t := range types {
+				if negated {
+					exclude[t] = true
+				} else {
+					include[t] = true
+				}
+			}
+			continue
+		}
+
+		switch {
+		case name == "third-party" || name == "3p":
+			f.thirdParty = 1
+			if negated {
+				f.thirdParty = -1
+			}
+		case name == "first-party" || name == "1p":
+			f.thirdParty = -1
+			if negated {
+				f.thirdParty = 1
+			}
+		case name == "important":
+			f.important = true
+		case name == "match-case", name == "all":
+		case strings.HasPrefix(name, "domain="), strings.HasPrefix(name, "from="):
+			_, list, _ := strings.Cut(name, "=")
+			for _, domain := range strings.Split(list, "|") {
+				if strings.HasPrefix(domain, "~") {
+					f.notDomains = append(f.notDomains, strings.TrimPrefix(domain, "~"))
+				} else if domain != "" {
+					f.domains = append(f.domains, domain)
+				}
+			}
+		default:
+			// Options such as redirect, csp or removeparam change more than
+			// whether a request loads; skip those filters rather than misapply them
+			return false
+		}
+	}
+
+	if len(include) > 0 || len(exclude) > 0 {
+		f.types = include
+		if len(include) == 0 {
+			for _, t := range resourceTypes {
+				f.types[t] = true
+			}
+		}
+		for t := range exclude {
+			delete(f.types, t)
+		}
+	}
+	return true
+}
+
+// hostname returns the hostname of a filter like ||example.com^, if that is all it matches
+func (f *networkFilter) hostname() (string, bool) {
+	if !f.hostAnchor || !strings.HasSuffix(f.glob, "^*") {
+		return "", false
+	}
+	host := strings.TrimSuffix(f.glob, "^*")
+	if host == "" || strings.IndexFunc(host, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '-')
+	}) >= 0 {
+		return "", false
+	}
+	return host, true
+}
+
+// token returns the longest literal run of the pattern that always appears
+// as a whole token in matching URLs, for indexing
+func (f *networkFilter) token() string {
+	best := ""
+	p := f.glob
+	for i := 0; i < len(p); {
+		if !isTokenChar(p[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(p) && isTokenChar(p[j]) {
+			j++
+		}
+		// A token next to a wildcard may only be part of a longer token in the URL.
+		// Unanchored globs start and end with one, so the edges are anchors.
+		usable := (i == 0 || p[i-1] != '*') && (j == len(p) || p[j] != '*')
+		if usable && !commonTokens[p[i:j]] && j-i > len(best) {
+			best = p[i:j]
+		}
+		i = j
+	}
+	return best
+}
+
+// matches reports whether the filter applies to a request
+func (f *networkFilter) matches(req *filterRequest) bool {
+	if f.types != nil && !f.types[req.resourceType] {
+		return false
+	}
+	if f.thirdParty == 1 && !req.thirdParty || f.thirdParty == -1 && req.thirdParty {
+		return false
+	}
+	if len(f.domains) > 0 && !matchDomain(req.pageHost, f.domains) {
+		return false
+	}
+	if matchDomain(req.pageHost, f.notDomains) {
+		return false
+	}
+
+	if f.regex != nil {
+		return f.regex.MatchString(req.url)
+	}
+	if f.hostAnchor {
+		for _, start := range req.hostStarts {
+			if wildcardMatch(f.glob, req.url[start:]) {
+				return true
+			}
+		}
+		return false
+	}
+	return wildcardMatch(f.glob, req.url)
+}
+
+// wildcardMatch matches s against a pattern where * matches any run of
+// characters and ^ matches a separator character or the end of s
+func wildcardMatch(p, s string) bool {
+	pi, si := 0, 0
+	star, mark := -1, 0
+	for si < len(s) {
+		switch {
+		case pi < len(p) && p[pi] == '*':
+			star, mark = pi, si
+			pi++
+		case pi < len(p) && (p[pi] == '^' && isSeparator(s[si]) || p[pi] == s[si]):
+			pi++
+			si++
+		case star >= 0:
+			pi = star + 1
+			mark++
+			si = mark
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && (p[pi] == '*' || p[pi] == '^') {
+		pi++
+	}
+	return pi == len(p)
+}
+
+// isTokenChar reports whether c can be part of an index token
+func isTokenChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '%'
+}
+
+// isSeparator reports whether c matches the ^ placeholder
+func isSeparator(c byte) bool {
+	return !(isTokenChar(c) || c >= 'A' && c <= 'Z' || c == '_' || c == '-' || c == '.')
+}
+
+// filterSet indexes filters by hostname and by token so a request is only
+// checked against the few filters that could match it
+type filterSet struct {
+	hosts   map[string][]*networkFilter
+	tokens  map[string][]*networkFilter
+	generic []*networkFilter
+	size    int
+}
+
+func newFilterSet() *filterSet {
+	return &filterSet{
+		hosts:  make(map[string][]*networkFilter),
+		tokens: make(map[string][]*networkFilter),
+	}
+}
+
+// add indexes a filter
+func (s *filterSet) add(f *networkFilter) {
+	s.size++
+	if host, ok := f.hostname(); ok {
+		s.hosts[host] = append(s.hosts[host], f)
+		return
+	}
+	if f.regex == nil {
+		if token := f.token(); token != "" {
+			s.tokens[token] = append(s.tokens[token], f)
+			return
+		}
+	}
+	s.generic = append(s.generic, f)
+}
+
+// match returns the first filter matching the request, if any
+func (s *filterSet) match(req *filterRequest) *networkFilter {
+	// The host and each parent domain
+	for host := req.host; host != ""; {
+		for _, f := range s.hosts[host] {
+			if f.matches(req) {
+				return f
+			}
+		}
+		_, parent, found := strings.Cut(host, ".")
+		if !found {
+			break
+		}
+		host = parent
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < len(req.url); {
+		if !isTokenChar(req.url[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(req.url) && isTokenChar(req.url[j]) {
+			j++
+		}
+		token := req.url[i:j]
+		if !seen[token] {
+			seen[token] = true
+			for _, f := range s.tokens[token] {
+				if f.matches(req) {
+					return f
+				}
+			}
+		}
+		i = j
+	}
+
+	for _, f := range s.generic {
+		if f.matches(req) {
+			return f
+		}
+	}
+	return nil
+}
+
+// filterMatcher holds the compiled filters of all lists
+type filterMatcher struct {
+	important  *filterSet
+	block      *filterSet
+	exceptions *filterSet
+}
+
+// newFilterMatcher creates an empty matcher
+func newFilterMatcher() *filterMatcher {
+	return &filterMatcher{
+		important:  newFilterSet(),
+		block:      newFilterSet(),
+		exceptions: newFilterSet(),
+	}
+}
+
+// add compiles a filter line, reporting whether it was a usable network filter
+func (m *filterMatcher) add(line string) bool {
+	f, ok := parseFilter(line)
+	if !ok {
+		return false
+	}
+	switch {
+	case f.exception:
+		m.exceptions.add(f)
+	case f.important:
+		m.important.add(f)
+	default:
+		m.block.add(f)
+	}
+	return true
+}
+
+// match returns the blocking filter for a request, or nil if it may load
+func (m *filterMatcher) match(req *filterRequest) *networkFilter {
+	if f := m.important.match(req); f != nil {
+		return f
+	}
+	if f := m.block.match(req); f != nil && m.exceptions.match(req) == nil {
+		return f
+	}
+	return nil
+}
+
+// newFilterRequest prepares a request URL for matching
+func newFilterRequest(resourceType proto.NetworkResourceType, u *url.URL, pageHost string) *filterRequest {
+	req := &filterRequest{
+		url:          strings.ToLower(u.String()),
+		host:         strings.ToLower(u.Hostname()),
+		pageHost:     strings.ToLower(pageHost),
+		resourceType: resourceType,
+	}
+
+	// Positions where a hostname label starts, for || anchors
+	if i := strings.Index(req.url, "://"); i >= 0 {
+		start := i + 3
+		req.hostStarts = append(req.hostStarts, start)
+		for j := start; j < len(req.url); j++ {
+			c := req.url[j]
+			if c == '/' || c == '?' || c == '#' || c == ':' {
+				break
+			}
+			if c == '.' {
+				req.hostStarts = append(req.hostStarts, j+1)
+			}
+		}
+	}
+
+	site, err := publicsuffix.EffectiveTLDPlusOne(req.host)
+	pageSite, pageErr := publicsuffix.EffectiveTLDPlusOne(req.pageHost)
+	if err != nil || pageErr != nil {
+		req.thirdParty = req.host != req.pageHost
+	} else {
+		req.thirdParty = site != pageSite
+	}
+	return req
+}
+
+// FilterEngine applies EasyList/uBlock-style network filter lists to
+// requests made during renders, reloading the lists when they change
+type FilterEngine struct {
+	proxy *HeadlessProxy
+
+	mu       sync.RWMutex
+	matcher  *filterMatcher
+	modTimes map[string]time.Time
+}
+
+// NewFilterEngine creates a new filter engine for the configured lists
+func NewFilterEngine(proxy *HeadlessProxy) *FilterEngine {
+	return &FilterEngine{
+		proxy:   proxy,
+		matcher: newFilterMatcher(),
+	}
+}
+
+// Load reads and compiles all filter lists, replacing the current filters
+func (e *FilterEngine) Load() error {
+	matcher := newFilterMatcher()
+	modTimes := make(map[string]time.Time, len(e.proxy.FilterLists))
+	filters, skipped := 0, 0
+
+	for _, path := range e.proxy.FilterLists {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open filter list: %v", err)
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("failed to stat filter list: %v", err)
+		}
+		modTimes[path] = info.ModTime()
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if matcher.add(scanner.Text()) {
+				filters++
+			} else {
+				skipped++
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read filter list %s: %v", path, err)
+		}
+	}
+
+	e.mu.Lock()
+	e.matcher = matcher
+	e.modTimes = modTimes
+	e.mu.Unlock()
+
+	e.proxy.logger.Info("filter lists loaded",
+		zap.Strings("files", e.proxy.FilterLists),
+		zap.Int("filters", filters),
+		zap.Int("skipped_lines", skipped),
+	)
+	return nil
+}
+
+// StartWatching reloads the filter lists whenever one of the files changes
+func (e *FilterEngine) StartWatching(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Duration(e.proxy.FilterListReload) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if e.changed() {
+					if err := e.Load(); err != nil {
+						// Keep the previous filters until the lists are readable again
+						e.proxy.logger.Error("failed to reload filter lists", zap.Error(err))
+					}
+				}
+			}
+		}
+	}()
+}
+
+// changed reports whether any filter list was modified since it was loaded
+func (e *FilterEngine) changed() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, path := range e.proxy.FilterLists {
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().Equal(e.modTimes[path]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Blocked reports whether a request made by a page on pageHost should be blocked
+func (e *FilterEngine) Blocked(resourceType proto.NetworkResourceType, u *url.URL, pageHost string) bool {
+	req := newFilterRequest(resourceType, u, pageHost)
+
+	// The page itself is never blocked, even if a list covers its site
+	if resourceType == proto.NetworkResourceTypeDocument && !req.thirdParty {
+		return false
+	}
+
+	e.mu.RLock()
+	matcher := e.matcher
+	e.mu.RUnlock()
+
+	return matcher.match(req) != nil
+}
@@ -0,0 +1,64 @@
+package headlessproxy
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/go-rod/rod/lib/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterMatcher(t *testing.T) {
+	matcher := newFilterMatcher()
+	for _, line := range []string{
+		"! Title: test list",
+		"example.com##.ad-banner",
+		"||ads.example.net^",
+		"||tracker.io^$third-party",
+		"/banner/*/ad_",
+		"|https://cdn.example.org/pixel.gif|",
+		"@@||ads.example.net/allowed/",
+		"||fonts.example.com^$font,domain=shop.test|~blog.shop.test",
+		"/\\/analytics\\.[a-z]+\\.js/$script",
+		"||cdn.example.org^$redirect=noop.js",
+	} {
+		matcher.add(line)
+	}
+
+	blocked := func(resourceType proto.NetworkResourceType, rawURL, pageHost string) bool {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return matcher.match(newFilterRequest(resourceType, u, pageHost)) != nil
+	}
+
+	// Hostname anchors match the domain and its subdomains only
+	assert.True(t, blocked(proto.NetworkResourceTypeScript, "https://ads.example.net/x.js", "site.test"))
+	assert.True(t, blocked(proto.NetworkResourceTypeImage, "https://a.ads.example.net/x.gif", "site.test"))
+	assert.False(t, blocked(proto.NetworkResourceTypeScript, "https://badads.example.net/x.js", "site.test"))
+
+	// Exceptions win over blocking filters
+	assert.False(t, blocked(proto.NetworkResourceTypeScript, "https://ads.example.net/allowed/x.js", "site.test"))
+
+	// Third-party filters skip first-party requests
+	assert.True(t, blocked(proto.NetworkResourceTypeScript, "https://tracker.io/t.js", "site.test"))
+	assert.False(t, blocked(proto.NetworkResourceTypeScript, "https://tracker.io/t.js", "www.tracker.io"))
+
+	// Wildcards, start and end anchors
+	assert.True(t, blocked(proto.NetworkResourceTypeImage, "https://site.test/banner/top/ad_1.png", "site.test"))
+	assert.True(t, blocked(proto.NetworkResourceTypeImage, "https://cdn.example.org/pixel.gif", "site.test"))
+	assert.False(t, blocked(proto.NetworkResourceTypeImage, "https://cdn.example.org/pixel.gif?x=1", "site.test"))
+
+	// Type and domain options
+	assert.True(t, blocked(proto.NetworkResourceTypeFont, "https://fonts.example.com/a.woff2", "shop.test"))
+	assert.False(t, blocked(proto.NetworkResourceTypeStylesheet, "https://fonts.example.com/a.css", "shop.test"))
+	assert.False(t, blocked(proto.NetworkResourceTypeFont, "https://fonts.example.com/a.woff2", "blog.shop.test"))
+
+	// Regular expressions
+	assert.True(t, blocked(proto.NetworkResourceTypeScript, "https://site.test/js/analytics.min.js", "site.test"))
+	assert.False(t, blocked(proto.NetworkResourceTypeImage, "https://site.test/js/analytics.min.js", "site.test"))
+
+	// Filters with unsupported options are skipped
+	assert.False(t, blocked(proto.NetworkResourceTypeScript, "https://cdn.example.org/lib.js", "site.test"))
+}
@@ -0,0 +1,440 @@
+package headlessproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+	"go.uber.org/zap"
+)
+
+// HAR 1.2 document types, see http://www.softwareishard.com/blog/har-12-spec/
+
+// harLog is the root object of a HAR document
+type harLog struct {
+	Log harLogBody `json:"log"`
+}
+
+type harLogBody struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Pages   []harPage  `json:"pages"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harPage struct {
+	StartedDateTime string         `json:"startedDateTime"`
+	ID              string         `json:"id"`
+	Title           string         `json:"title"`
+	PageTimings     harPageTimings `json:"pageTimings"`
+}
+
+type harPageTimings struct {
+	OnContentLoad float64 `json:"onContentLoad"`
+	OnLoad        float64 `json:"onLoad"`
+}
+
+type harEntry struct {
+	Pageref         string      `json:"pageref,omitempty"`
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// harPending tracks the CDP timestamps of a request while it is in flight
+type harPending struct {
+	entry      *harEntry
+	started    time.Time
+	requestTS  float64
+	responseTS float64
+	finished   bool
+}
+
+// harRecorder records the network activity of a page as HAR entries
+type harRecorder struct {
+	page        *rod.Page
+	maxBodySize int
+	startTime   time.Time
+	cancel      context.CancelFunc
+
+	mu       sync.Mutex
+	entries  []*harPending
+	inflight map[proto.NetworkRequestID]*harPending
+}
+
+// newHARRecorder creates a recorder for the given page. Response bodies
+// larger than maxBodySize are omitted; a negative value omits all bodies.
+func newHARRecorder(page *rod.Page, maxBodySize int) *harRecorder {
+	return &harRecorder{
+		page:        page,
+		maxBodySize: maxBodySize,
+		inflight:    make(map[proto.NetworkRequestID]*harPending),
+	}
+}
+
+// Start subscribes to the page's network events
+func (rec *harRecorder) Start(ctx context.Context) {
+	ctx, rec.cancel = context.WithCancel(ctx)
+	rec.startTime = time.Now()
+
+	wait := rec.page.Context(ctx).EachEvent(
+		rec.onRequestWillBeSent,
+		rec.onResponseReceived,
+		rec.onLoadingFinished,
+		rec.onLoadingFailed,
+	)
+	go wait()
+}
+
+// Stop unsubscribes from the page's network events
+func (rec *harRecorder) Stop() {
+	if rec.cancel != nil {
+		rec.cancel()
+	}
+}
+
+func (rec *harRecorder) onRequestWillBeSent(e *proto.NetworkRequestWillBeSent) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	// A redirect reuses the request ID, so close out the previous hop first
+	if prev, ok := rec.inflight[e.RequestID]; ok && e.RedirectResponse != nil {
+		rec.applyResponse(prev, e.RedirectResponse, float64(e.Timestamp))
+		prev.entry.Response.RedirectURL = e.Request.URL
+		prev.finished = true
+		rec.finishTimings(prev, float64(e.Timestamp))
+	}
+
+	req := harRequest{
+		Method:      e.Request.Method,
+		URL:         e.Request.URL,
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(e.Request.Headers),
+		QueryString: harQueryString(e.Request.URL),
+		HeadersSize: -1,
+		BodySize:    len(e.Request.PostData),
+	}
+	if e.Request.HasPostData {
+		req.PostData = &harPostData{
+			MimeType: harHeaderValue(req.Headers, "Content-Type"),
+			Text:     e.Request.PostData,
+		}
+	}
+
+	started := time.Now()
+	pending := &harPending{
+		entry: &harEntry{
+			Pageref:         "page_1",
+			StartedDateTime: started.Format(time.RFC3339Nano),
+			Request:         req,
+			Response: harResponse{
+				Cookies:     []harNameValue{},
+				Headers:     []harNameValue{},
+				HeadersSize: -1,
+				BodySize:    -1,
+			},
+			Timings: harTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1},
+			Comment: string(e.Type),
+		},
+		started:   started,
+		requestTS: float64(e.Timestamp),
+	}
+	rec.entries = append(rec.entries, pending)
+	rec.inflight[e.RequestID] = pending
+}
+
+func (rec *harRecorder) onResponseReceived(e *proto.NetworkResponseReceived) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if pending, ok := rec.inflight[e.RequestID]; ok {
+		rec.applyResponse(pending, e.Response, float64(e.Timestamp))
+	}
+}
+
+func (rec *harRecorder) onLoadingFinished(e *proto.NetworkLoadingFinished) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if pending, ok := rec.inflight[e.RequestID]; ok {
+		pending.entry.Response.BodySize = int(e.EncodedDataLength)
+		pending.finished = true
+		rec.finishTimings(pending, float64(e.Timestamp))
+	}
+}
+
+func (rec *harRecorder) onLoadingFailed(e *proto.NetworkLoadingFailed) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if pending, ok := rec.inflight[e.RequestID]; ok {
+		pending.entry.Response.StatusText = e.ErrorText
+		pending.entry.Comment = strings.TrimSpace(pending.entry.Comment + " failed: " + e.ErrorText)
+		rec.finishTimings(pending, float64(e.Timestamp))
+		delete(rec.inflight, e.RequestID)
+	}
+}
+
+// applyResponse fills in the response part of an entry
+func (rec *harRecorder) applyResponse(pending *harPending, resp *proto.NetworkResponse, ts float64) {
+	pending.responseTS = ts
+	entry := pending.entry
+	entry.Response.Status = resp.Status
+	entry.Response.StatusText = resp.StatusText
+	entry.Response.HTTPVersion = harHTTPVersion(resp.Protocol)
+	entry.Response.Headers = harHeaders(resp.Headers)
+	entry.Response.Content = harContent{Size: int(resp.EncodedDataLength), MimeType: resp.MIMEType}
+	entry.Request.HTTPVersion = entry.Response.HTTPVersion
+	entry.ServerIPAddress = resp.RemoteIPAddress
+}
+
+// finishTimings derives the send/wait/receive phases from CDP timestamps
+func (rec *harRecorder) finishTimings(pending *harPending, endTS float64) {
+	entry := pending.entry
+	if pending.responseTS > 0 {
+		entry.Timings.Wait = (pending.responseTS - pending.requestTS) * 1000
+		entry.Timings.Receive = (endTS - pending.responseTS) * 1000
+	} else {
+		entry.Timings.Wait = (endTS - pending.requestTS) * 1000
+	}
+	entry.Time = entry.Timings.Wait + entry.Timings.Receive
+}
+
+// Build assembles the HAR document, fetching response bodies that fit the size limit
+func (rec *harRecorder) Build(title string) *harLog {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	for id, pending := range rec.inflight {
+		if pending.finished && rec.maxBodySize >= 0 {
+			rec.loadBody(id, pending.entry)
+		}
+	}
+
+	// Formatted timestamps don't sort as strings across time zone offsets
+	// or trailing zeros, so entries are ordered by their start time
+	pendings := make([]*harPending, len(rec.entries))
+	copy(pendings, rec.entries)
+	sort.SliceStable(pendings, func(i, j int) bool {
+		return pendings[i].started.Before(pendings[j].started)
+	})
+	entries := make([]harEntry, 0, len(pendings))
+	for _, pending := range pendings {
+		entries = append(entries, *pending.entry)
+	}
+
+	return &harLog{Log: harLogBody{
+		Version: "1.2",
+		Creator: harCreator{Name: "caddy-headless-proxy", Version: "1.0.0"},
+		Pages: []harPage{{
+			StartedDateTime: rec.startTime.Format(time.RFC3339Nano),
+			ID:              "page_1",
+			Title:           title,
+			PageTimings:     harPageTimings{OnContentLoad: -1, OnLoad: -1},
+		}},
+		Entries: entries,
+	}}
+}
+
+// loadBody attaches the response body to an entry if it is within the limit
+func (rec *harRecorder) loadBody(id proto.NetworkRequestID, entry *harEntry) {
+	if entry.Response.BodySize > rec.maxBodySize {
+		entry.Response.Content.Comment = fmt.Sprintf("body omitted: %d bytes exceeds limit", entry.Response.BodySize)
+		return
+	}
+
+	body, err := proto.NetworkGetResponseBody{RequestID: id}.Call(rec.page)
+	if err != nil {
+		return
+	}
+	if len(body.Body) > rec.maxBodySize {
+		entry.Response.Content.Comment = fmt.Sprintf("body omitted: %d bytes exceeds limit", len(body.Body))
+		return
+	}
+
+	entry.Response.Content.Text = body.Body
+	if body.Base64Encoded {
+		entry.Response.Content.Encoding = "base64"
+	} else {
+		entry.Response.Content.Size = len(body.Body)
+	}
+}
+
+// harHeaders converts CDP headers to HAR name/value pairs with sensitive values redacted
+func harHeaders(headers proto.NetworkHeaders) []harNameValue {
+	raw := make(http.Header)
+	for k, v := range headers {
+		raw.Add(k, v.Str())
+	}
+
+	sanitized := sanitizeHeaders(raw)
+	result := []harNameValue{}
+	for k, values := range raw {
+		if _, ok := sanitized[k]; !ok {
+			result = append(result, harNameValue{Name: k, Value: "[REDACTED]"})
+			continue
+		}
+		for _, v := range values {
+			result = append(result, harNameValue{Name: k, Value: v})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// harHeaderValue returns the first value of a header in a HAR header list
+func harHeaderValue(headers []harNameValue, name string) string {
+	for _, header := range headers {
+		if strings.EqualFold(header.Name, name) {
+			return header.Value
+		}
+	}
+	return ""
+}
+
+// harQueryString extracts the query parameters of a URL in the order they
+// appear, skipping malformed pairs like url.ParseQuery does
+func harQueryString(rawURL string) []harNameValue {
+	result := []harNameValue{}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return result
+	}
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		if pair == "" || strings.Contains(pair, ";") {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(name)
+		if err != nil {
+			continue
+		}
+		value, err = url.QueryUnescape(value)
+		if err != nil {
+			continue
+		}
+		result = append(result, harNameValue{Name: name, Value: value})
+	}
+	return result
+}
+
+// harHTTPVersion maps a CDP protocol name to a HAR HTTP version
+func harHTTPVersion(protocol string) string {
+	switch strings.ToLower(protocol) {
+	case "h2":
+		return "HTTP/2.0"
+	case "h3", "h3-29":
+		return "HTTP/3.0"
+	case "http/1.0":
+		return "HTTP/1.0"
+	case "":
+		return "HTTP/1.1"
+	default:
+		return strings.ToUpper(protocol)
+	}
+}
+
+// wantsHAR reports whether network activity should be recorded for a request
+func (h *HeadlessProxy) wantsHAR(r *http.Request) bool {
+	return h.getRenderOptions(r).Format == "har" || h.harDebugRequested(r)
+}
+
+// harDebugRequested reports whether a HAR should be written to the debug directory
+func (h *HeadlessProxy) harDebugRequested(r *http.Request) bool {
+	return h.HARDir != "" && h.HARHeader != "" && r.Header.Get(h.HARHeader) != ""
+}
+
+// writeHARFile stores a HAR document in the debug directory
+func (h *HeadlessProxy) writeHARFile(requestID string, har *harLog) {
+	data, err := json.MarshalIndent(har, "", "  ")
+	if err != nil {
+		h.logger.Error("failed to encode HAR", zap.Error(err))
+		return
+	}
+
+	name := fmt.Sprintf("%s-%s.har", time.Now().Format("20060102T150405"), filepath.Base(requestID))
+	path := filepath.Join(h.HARDir, name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		h.logger.Error("failed to write HAR file", zap.String("path", path), zap.Error(err))
+		return
+	}
+
+	h.logger.Info("HAR written",
+		zap.String("request_id", requestID),
+		zap.String("path", path),
+		zap.Int("entries", len(har.Log.Entries)),
+	)
+}
@@ -0,0 +1,104 @@
+package headlessproxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-rod/rod/lib/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/ysmood/gson"
+)
+
+func TestHARHeaders(t *testing.T) {
+	headers := harHeaders(proto.NetworkHeaders{
+		"content-type":  gson.New("text/html"),
+		"Authorization": gson.New("Bearer secret"),
+		"cookie":        gson.New("session=abc"),
+		"Set-Cookie":    gson.New("a=1\nb=2"),
+		"X-Request-Id":  gson.New("42"),
+		"Accept":        gson.New("*/*"),
+	})
+
+	// Names are canonical and sorted; credentials never reach the HAR
+	assert.Equal(t, []harNameValue{
+		{Name: "Accept", Value: "*/*"},
+		{Name: "Authorization", Value: "[REDACTED]"},
+		{Name: "Content-Type", Value: "text/html"},
+		{Name: "Cookie", Value: "[REDACTED]"},
+		{Name: "Set-Cookie", Value: "[REDACTED]"},
+		{Name: "X-Request-Id", Value: "42"},
+	}, headers)
+	assert.Equal(t, "text/html", harHeaderValue(headers, "content-type"))
+	assert.Equal(t, "", harHeaderValue(headers, "Referer"))
+
+	assert.Equal(t, []harNameValue{}, harHeaders(nil))
+}
+
+func TestHARQueryString(t *testing.T) {
+	tests := []struct {
+		url   string
+		query []harNameValue
+	}{
+		{
+			"https://example.com/search?q=caddy+proxy&page=2&tag=b&tag=a",
+			[]harNameValue{{"q", "caddy proxy"}, {"page", "2"}, {"tag", "b"}, {"tag", "a"}},
+		},
+		{"https://example.com/?empty=&flag", []harNameValue{{"empty", ""}, {"flag", ""}}},
+		{"https://example.com/?name=%C3%A9t%C3%A9", []harNameValue{{"name", "été"}}},
+		// Malformed pairs are skipped
+		{"https://example.com/?a=%zz&b=1;c=2&d=4", []harNameValue{{"d", "4"}}},
+		{"https://example.com/", []harNameValue{}},
+		{"://bad", []harNameValue{}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.query, harQueryString(tt.url), tt.url)
+	}
+}
+
+func TestHARHTTPVersion(t *testing.T) {
+	tests := map[string]string{
+		"h2":       "HTTP/2.0",
+		"H2":       "HTTP/2.0",
+		"h3":       "HTTP/3.0",
+		"h3-29":    "HTTP/3.0",
+		"http/1.0": "HTTP/1.0",
+		"http/1.1": "HTTP/1.1",
+		"":         "HTTP/1.1",
+		"quic":     "QUIC",
+	}
+	for protocol, version := range tests {
+		assert.Equal(t, version, harHTTPVersion(protocol), protocol)
+	}
+}
+
+func TestHARBuildOrder(t *testing.T) {
+	base := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	berlin := time.FixedZone("CEST", 2*60*60)
+	rec := newHARRecorder(nil, -1)
+	rec.startTime = base
+	for _, started := range []time.Time{
+		base.Add(30 * time.Millisecond),
+		// Formatted in another zone, this sorts last as a string
+		base.Add(10 * time.Millisecond).In(berlin),
+		// Without fractional seconds, this sorts after its fractions as a string
+		base,
+		base.Add(20 * time.Millisecond),
+	} {
+		rec.entries = append(rec.entries, &harPending{
+			entry:   &harEntry{StartedDateTime: started.Format(time.RFC3339Nano), Request: harRequest{URL: started.UTC().Format("/15:04:05.000")}},
+			started: started,
+		})
+	}
+
+	har := rec.Build("Example")
+	var urls []string
+	for _, entry := range har.Log.Entries {
+		urls = append(urls, entry.Request.URL)
+	}
+	assert.Equal(t, []string{"/08:00:00.000", "/08:00:00.010", "/08:00:00.020", "/08:00:00.030"}, urls)
+	assert.Equal(t, "Example", har.Log.Pages[0].Title)
+	assert.Equal(t, "2024-05-01T08:00:00Z", har.Log.Pages[0].StartedDateTime)
+
+	// Recorded entries keep the order they were seen in
+	assert.Equal(t, "/08:00:00.030", rec.entries[0].entry.Request.URL)
+}
@@ -2,15 +2,15 @@ package headlessproxy
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
@@ -20,12 +20,12 @@ import (
 	"github.com/go-rod/rod"
 	"github.com/go-rod/rod/lib/launcher"
 	"github.com/go-rod/rod/lib/proto"
-	"github.com/go-rod/rod/lib/utils"
 	"go.uber.org/zap"
 )
 
 func init() {
 	caddy.RegisterModule(HeadlessProxy{})
+	caddy.RegisterModule(PurgeAdmin{})
 	httpcaddyfile.RegisterHandlerDirective("headless_proxy", parseCaddyfile)
 }
 
@@ -40,6 +40,12 @@ type HeadlessProxy struct {
 	// Browser monitor
 	monitor *BrowserMonitor
 
+	// Raw-vs-rendered diff sampler
+	diffSampler *DiffSampler
+
+	// Sitemap-driven cache warmer
+	warmer *CacheWarmer
+
 	// Start time for uptime tracking
 	startTime time.Time
 
@@ -52,9 +58,12 @@ type HeadlessProxy struct {
 	// Timeout for browser operations in seconds
 	Timeout int `json:"timeout,omitempty"`
 
-	// UserAgent to use for the headless browser
+	// UserAgent to use for the headless browser (empty uses the launched browser's own)
 	UserAgent string `json:"user_agent,omitempty"`
 
+	// Whether to present the client's own User-Agent and Client Hints upstream
+	ForwardUserAgent bool `json:"forward_user_agent,omitempty"`
+
 	// Whether to enable JavaScript
 	EnableJS bool `json:"enable_js,omitempty"`
 
@@ -64,9 +73,63 @@ type HeadlessProxy struct {
 	// Headers to forward to the target
 	ForwardHeaders []string `json:"forward_headers,omitempty"`
 
-	// Cache TTL in seconds (0 means no caching)
+	// Cache TTL in seconds for responses without upstream freshness information (0 means no caching)
 	CacheTTL int `json:"cache_ttl,omitempty"`
 
+	// Whether cache_ttl also caps the freshness lifetime upstream asks for
+	CacheTTLCap bool `json:"cache_ttl_cap,omitempty"`
+
+	// Paths for which caching is forced or forbidden regardless of upstream headers
+	CacheRules []CacheRule `json:"cache_rules,omitempty"`
+
+	// Template of the page part of cache keys, using Caddy placeholders (defaults to host and path)
+	CacheKey string `json:"cache_key,omitempty"`
+
+	// Query parameters kept in cache keys, where * matches any characters (empty keeps all)
+	CacheKeyQueryAllow []string `json:"cache_key_query_allow,omitempty"`
+
+	// Query parameters dropped from cache keys (defaults to utm_* without an allowlist)
+	CacheKeyQueryDeny []string `json:"cache_key_query_deny,omitempty"`
+
+	// Cookies included in cache keys, where * matches any characters (defaults to all forwarded cookies)
+	CacheKeyCookies []string `json:"cache_key_cookies,omitempty"`
+
+	// Response header showing the hashed cache key of a request, for debugging
+	CacheKeyHeader string `json:"cache_key_header,omitempty"`
+
+	// Maximum total size of cached responses in bytes
+	CacheMaxSize int64 `json:"cache_max_size,omitempty"`
+
+	// Where cached responses are kept: memory, disk (in cache_dir) or storage (Caddy's storage)
+	CacheStore string `json:"cache_store,omitempty"`
+
+	// Directory of the disk cache
+	CacheDir string `json:"cache_dir,omitempty"`
+
+	// Seconds an expired response may be served while it is re-rendered in the background
+	StaleWhileRevalidate int `json:"stale_while_revalidate,omitempty"`
+
+	// Seconds an expired response may be served when rendering fails
+	StaleIfError int `json:"stale_if_error,omitempty"`
+
+	// Seconds a request waits for an identical render in flight (defaults to the timeout)
+	CoalesceTimeout int `json:"coalesce_timeout,omitempty"`
+
+	// Sitemaps whose URLs are rendered into the cache in the background, as URLs or local files
+	WarmSitemaps []string `json:"warm_sitemaps,omitempty"`
+
+	// Seconds between cache warming runs
+	WarmInterval int `json:"warm_interval,omitempty"`
+
+	// Which cached URLs a warming run renders again: lastmod (expired or changed since their render) or always
+	WarmRefresh string `json:"warm_refresh,omitempty"`
+
+	// Maximum number of renders the cache warmer runs at once
+	WarmConcurrency int `json:"warm_concurrency,omitempty"`
+
+	// Fraction of max_browsers renders in flight above which the cache warmer waits
+	WarmPoolShare float64 `json:"warm_pool_share,omitempty"`
+
 	// Maximum browser instances to keep in the pool
 	MaxBrowsers int `json:"max_browsers,omitempty"`
 
@@ -79,23 +142,157 @@ type HeadlessProxy struct {
 	// Whether to minify HTML, CSS, and JS
 	MinifyContent bool `json:"minify_content,omitempty"`
 
+	// Output format for rendered pages: "html" (default), "har", "seo-report",
+	// "png", "webp", "pdf", "json" or "markdown"
+	Output string `json:"output,omitempty"`
+
+	// Output formats clients may choose between with the Accept header
+	// (the first one is used when the client has no preference)
+	Formats []string `json:"formats,omitempty"`
+
+	// Directory to write HAR captures to for requests carrying HARHeader
+	HARDir string `json:"har_dir,omitempty"`
+
+	// Request header that triggers writing a HAR capture to HARDir
+	HARHeader string `json:"har_header,omitempty"`
+
+	// Maximum response body size in bytes kept in HAR captures (negative omits bodies)
+	HARMaxBodySize int `json:"har_max_body_size,omitempty"`
+
+	// Whether an uncaught exception or console error fails the render
+	FailOnJSError bool `json:"fail_on_js_error,omitempty"`
+
+	// Fraction of renders compared against the raw upstream HTML in the background
+	DiffSampleRate float64 `json:"diff_sample_rate,omitempty"`
+
+	// Number of path segments render diff statistics are grouped by
+	DiffPrefixDepth int `json:"diff_prefix_depth,omitempty"`
+
+	// Custom device emulation profiles, by name (overriding built-in ones)
+	Profiles map[string]EmulationProfile `json:"profiles,omitempty"`
+
+	// Emulation profile used when no other profile is selected
+	DefaultProfile string `json:"default_profile,omitempty"`
+
+	// Request header naming the emulation profile to use
+	ProfileHeader string `json:"profile_header,omitempty"`
+
+	// Whether to pick a profile from the client's User-Agent and Client Hints
+	ProfileMatch bool `json:"profile_match,omitempty"`
+
+	// Browser locale, e.g. "de-DE", or "auto" to follow the client's Accept-Language
+	Locale string `json:"locale,omitempty"`
+
+	// IANA timezone the page runs in, e.g. "Europe/Berlin"
+	Timezone string `json:"timezone,omitempty"`
+
+	// Trusted request header overriding Timezone
+	TimezoneHeader string `json:"timezone_header,omitempty"`
+
+	// Position reported through the Geolocation API
+	Geolocation *Geolocation `json:"geolocation,omitempty"`
+
+	// Trusted request header overriding Geolocation as "latitude,longitude[,accuracy]"
+	GeolocationHeader string `json:"geolocation_header,omitempty"`
+
+	// Emulated prefers-color-scheme: "light" or "dark"
+	ColorScheme string `json:"color_scheme,omitempty"`
+
+	// Emulated prefers-reduced-motion: "no-preference" or "reduce"
+	ReducedMotion string `json:"reduced_motion,omitempty"`
+
+	// Emulated forced-colors: "none" or "active"
+	ForcedColors string `json:"forced_colors,omitempty"`
+
+	// Emulated CSS media type: "screen" or "print"
+	Media string `json:"media,omitempty"`
+
+	// Whether to honor the Sec-CH-Prefers-Color-Scheme and Sec-CH-Prefers-Reduced-Motion hints
+	MediaClientHints bool `json:"media_client_hints,omitempty"`
+
+	// Custom network and CPU throttle profiles, by name (overriding built-in ones)
+	Throttles map[string]ThrottleProfile `json:"throttles,omitempty"`
+
+	// Throttle profile applied to every render
+	Throttle string `json:"throttle,omitempty"`
+
+	// Trusted request header naming the throttle profile to use
+	ThrottleHeader string `json:"throttle_header,omitempty"`
+
+	// Resource types never loaded during renders, e.g. image, font, media
+	BlockResourceTypes []string `json:"block_resource_types,omitempty"`
+
+	// Domains (and their subdomains) whose requests are blocked
+	BlockDomains []string `json:"block_domains,omitempty"`
+
+	// Domains (and their subdomains) requests are limited to, besides the upstream
+	AllowDomains []string `json:"allow_domains,omitempty"`
+
+	// EasyList/uBlock-style network filter list files applied to every request
+	FilterLists []string `json:"filter_lists,omitempty"`
+
+	// Interval in seconds at which filter lists are checked for changes
+	FilterListReload int `json:"filter_list_reload,omitempty"`
+
+	// Canned responses for requests matching URL patterns
+	Mocks []MockRule `json:"mocks,omitempty"`
+
+	// Egress proxies browser traffic goes through: http, https or socks5 URLs,
+	// optionally with credentials for HTTP proxies
+	BrowserProxies []string `json:"browser_proxies,omitempty"`
+
+	// Whether each browser ("browser") or each render ("context") gets a proxy
+	BrowserProxyScope string `json:"browser_proxy_scope,omitempty"`
+
+	// How renders pick a proxy in context scope: "round_robin" or "sticky" per client IP
+	BrowserProxySelection string `json:"browser_proxy_selection,omitempty"`
+
+	// URL requested through each proxy to check its health (defaults to the upstream)
+	BrowserProxyCheckURL string `json:"browser_proxy_check_url,omitempty"`
+
+	// Interval in seconds between proxy health checks
+	BrowserProxyCheckInterval int `json:"browser_proxy_check_interval,omitempty"`
+
+	// Secret that X-Headless-* render overrides must be HMAC-signed with
+	// (empty ignores overrides)
+	OverrideSecret string `json:"override_secret,omitempty"`
+
+	// Plain HTTP client for fetching upstream documents without rendering
+	httpClient *http.Client
+
+	// Blocks requests by resource type and domain during renders
+	blocker *requestBlocker
+
+	// Blocks requests matching filter lists during renders
+	filterEngine *FilterEngine
+
+	// Egress proxies for browser traffic
+	proxyPool *ProxyPool
+
+	// Prepared responses of the mock rules
+	mocks []*mockResponse
+
+	// User-Agent of the launched browser, detected at startup
+	browserUserAgent string
+	identityLock     sync.RWMutex
+
 	// Browser pool
 	browserPool     []*rod.Browser
 	browserPoolLock sync.Mutex
 
 	// Cache for responses
-	cache     map[string]cacheEntry
-	cacheLock sync.RWMutex
+	cacheBackend CacheBackend
 
-	logger *zap.Logger
-}
+	// Cache keys being refreshed in the background
+	revalidating sync.Map
 
-// cacheEntry represents a cached response
-type cacheEntry struct {
-	Content    []byte
-	Headers    http.Header
-	StatusCode int
-	Expiration time.Time
+	// Renders in flight by cache key
+	renders renderGroup
+
+	// Number of renders in flight, for the cache warmer to yield to clients
+	activeRenders atomic.Int64
+
+	logger *zap.Logger
 }
 
 // Provision sets up the module.
@@ -105,10 +302,6 @@ func (h *HeadlessProxy) Provision(ctx caddy.Context) error {
 		h.Timeout = 30
 	}
 
-	if h.UserAgent == "" {
-		h.UserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36"
-	}
-
 	// Enable JS by default
 	if !h.EnableJS {
 		h.EnableJS = true
@@ -119,9 +312,67 @@ func (h *HeadlessProxy) Provision(ctx caddy.Context) error {
 		h.MaxBrowsers = 5
 	}
 
-	// Initialize cache if caching is enabled
-	if h.CacheTTL > 0 {
-		h.cache = make(map[string]cacheEntry)
+	// Render to HTML by default
+	if h.Output == "" {
+		h.Output = "html"
+	}
+
+	// Keep HAR bodies up to 64KiB by default
+	if h.HARMaxBodySize == 0 {
+		h.HARMaxBodySize = 64 * 1024
+	}
+
+	// Group render diff statistics by the first path segment by default
+	if h.DiffPrefixDepth <= 0 {
+		h.DiffPrefixDepth = 1
+	}
+
+	// Wait for renders in flight as long as a render may take by default
+	if h.CoalesceTimeout <= 0 {
+		h.CoalesceTimeout = h.Timeout
+	}
+
+	// Warm the cache hourly with two renders at most, using half of the browser pool
+	if h.WarmInterval <= 0 {
+		h.WarmInterval = 3600
+	}
+	if h.WarmRefresh == "" {
+		h.WarmRefresh = "lastmod"
+	}
+	if h.WarmConcurrency <= 0 {
+		h.WarmConcurrency = 2
+	}
+	if h.WarmPoolShare <= 0 {
+		h.WarmPoolShare = 0.5
+	}
+
+	// Keep up to 256MiB of cached responses in memory by default
+	if h.CacheStore == "" {
+		h.CacheStore = "memory"
+	}
+	if h.CacheMaxSize <= 0 {
+		h.CacheMaxSize = 256 << 20
+	}
+
+	// Leave campaign tracking parameters out of cache keys unless an allowlist decides
+	if h.CacheKeyQueryDeny == nil && len(h.CacheKeyQueryAllow) == 0 {
+		h.CacheKeyQueryDeny = defaultQueryDeny
+	}
+
+	// Check filter lists for changes every minute by default
+	if h.FilterListReload <= 0 {
+		h.FilterListReload = 60
+	}
+
+	// Assign browser proxies per browser, in turn, checked every 30 seconds by default
+	if h.BrowserProxyScope == "" {
+		h.BrowserProxyScope = "browser"
+	}
+	if h.BrowserProxySelection == "" {
+		h.BrowserProxySelection = "round_robin"
+	}
+	if h.BrowserProxyCheckInterval <= 0 {
+		h.BrowserProxyCheckInterval = 30
 	}
 
 	// Get a logger
@@ -129,12 +380,53 @@ func (h *HeadlessProxy) Provision(ctx caddy.Context) error {
 		zap.String("upstream", h.Upstream),
 	)
 
+	// Initialize cache if caching is enabled
+	if h.CacheTTL > 0 {
+		var err error
+		h.cacheBackend, err = h.newCacheBackend(ctx)
+		if err != nil {
+			return err
+		}
+		registerCache(h)
+	}
+
 	// Initialize metrics
 	h.initMetrics()
 
+	// Initialize egress proxies
+	if len(h.BrowserProxies) > 0 {
+		var err error
+		h.proxyPool, err = NewProxyPool(h)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Initialize the plain HTTP client, going through the same proxies as browsers
+	h.httpClient = &http.Client{Timeout: time.Duration(h.Timeout) * time.Second}
+	if h.proxyPool != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = h.proxyPool.ProxyURL
+		h.httpClient.Transport = transport
+	}
+
 	// Initialize resource optimizer
 	h.optimizer = NewResourceOptimizer(h)
 
+	// Initialize request mocking and blocking
+	mocks, err := loadMocks(h.Mocks)
+	if err != nil {
+		return err
+	}
+	h.mocks = mocks
+	h.blocker = newRequestBlocker(h)
+	if len(h.FilterLists) > 0 {
+		h.filterEngine = NewFilterEngine(h)
+		if err := h.filterEngine.Load(); err != nil {
+			return err
+		}
+	}
+
 	// Initialize browser pool
 	h.browserPool = make([]*rod.Browser, 0, h.MaxBrowsers)
 	h.initBrowserPool()
@@ -147,6 +439,26 @@ func (h *HeadlessProxy) Provision(ctx caddy.Context) error {
 	
 	// Start browser monitoring
 	h.monitor.StartMonitoring(h.ctx)
+
+	// Start render diff sampling
+	h.diffSampler = NewDiffSampler(h)
+	h.diffSampler.StartSampling(h.ctx)
+
+	// Render the pages listed in sitemaps into the cache
+	if len(h.WarmSitemaps) > 0 {
+		h.warmer = NewCacheWarmer(h)
+		h.warmer.StartWarming(h.ctx)
+	}
+
+	// Reload filter lists when they change
+	if h.filterEngine != nil {
+		h.filterEngine.StartWatching(h.ctx)
+	}
+
+	// Health-check egress proxies
+	if h.proxyPool != nil {
+		h.proxyPool.StartHealthChecks(h.ctx)
+	}
 	
 	// Record start time for uptime tracking
 	h.startTime = time.Now()
@@ -168,6 +480,9 @@ func (h *HeadlessProxy) Cleanup() error {
 		h.cancel()
 	}
 
+	// Stop serving purge requests
+	unregisterCache(h)
+
 	h.browserPoolLock.Lock()
 	defer h.browserPoolLock.Unlock()
 
@@ -188,6 +503,7 @@ func (h *HeadlessProxy) Cleanup() error {
 // ServeHTTP implements the caddyhttp.MiddlewareHandler interface.
 func (h *HeadlessProxy) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
 	requestStart := time.Now()
+	r = withRequestID(r)
 	
 	// Record request size
 	requestSize := 0
@@ -196,69 +512,224 @@ func (h *HeadlessProxy) ServeHTTP(w http.ResponseWriter, r *http.Request, next c
 	}
 	h.metrics.requestSize.WithLabelValues(r.Method).Observe(float64(requestSize))
 
-	// Check cache first
-	if content, headers, statusCode, found := h.getCachedResponse(r); found {
-		h.metrics.cacheHits.Inc()
-		h.logger.Info("serving cached response",
-			zap.String("path", r.URL.Path),
-			zap.Int("status", statusCode),
-			zap.Duration("response_time", time.Since(requestStart)),
-		)
-
-		for key, values := range headers {
-			for _, value := range values {
-				w.Header().Add(key, value)
-			}
+	// Responses bypass the cache until the request is found cacheable
+	h.setCacheStatus(w, r, cacheBypass, nil)
+
+	// Verify signed per-request overrides, keeping them away from upstream
+	r, overrides, err := h.requestOverrides(r)
+	if err != nil {
+		h.handleError(w, r, err, overrideErrorStatus(err))
+		return nil
+	}
+
+	// Negotiate the output format from the Accept header
+	opts := &renderOptions{Format: h.Output}
+	if len(h.Formats) > 0 && (overrides == nil || overrides.Format == "") {
+		w.Header().Add("Vary", "Accept")
+		format, ok := negotiateFormat(r.Header.Get("Accept"), h.Formats)
+		if !ok {
+			h.handleError(w, r, fmt.Errorf("%w: %s", ErrNotAcceptable, r.Header.Get("Accept")), http.StatusNotAcceptable)
+			return nil
 		}
+		opts.Format = format
+	}
 
-		w.WriteHeader(statusCode)
-		_, err := w.Write(content)
-		
-		// Record metrics
-		h.metrics.requestsTotal.WithLabelValues(r.Method, fmt.Sprintf("%d", statusCode)).Inc()
-		h.metrics.requestDuration.WithLabelValues(r.Method, fmt.Sprintf("%d", statusCode)).Observe(time.Since(requestStart).Seconds())
-		h.metrics.responseSize.WithLabelValues(r.Method, fmt.Sprintf("%d", statusCode)).Observe(float64(len(content)))
-		h.metrics.responseStatusCode.WithLabelValues(fmt.Sprintf("%d", statusCode)).Inc()
-		
-		return err
+	// Select the device to emulate
+	opts.Profile, opts.Device = h.selectProfile(r)
+	if h.ProfileHeader != "" {
+		w.Header().Add("Vary", h.ProfileHeader)
+	}
+	if h.ProfileMatch {
+		w.Header().Add("Vary", "User-Agent, Sec-CH-UA-Mobile")
+	}
+
+	// Resolve the User-Agent and Client Hints to present
+	h.resolveIdentity(r, opts)
+	if h.ForwardUserAgent {
+		w.Header().Add("Vary", "User-Agent, Sec-CH-UA, Sec-CH-UA-Mobile, Sec-CH-UA-Platform")
+	}
+
+	// Resolve the locale, timezone and position to emulate
+	h.resolveLocale(r, opts)
+	if h.Locale == "auto" {
+		w.Header().Add("Vary", "Accept-Language")
+	}
+	if h.TimezoneHeader != "" {
+		w.Header().Add("Vary", h.TimezoneHeader)
+	}
+	if h.GeolocationHeader != "" {
+		w.Header().Add("Vary", h.GeolocationHeader)
+	}
+
+	// Resolve the CSS media features to emulate, asking clients for their preferences
+	h.resolveMedia(r, opts)
+	if vary := h.mediaVary(); len(vary) > 0 {
+		w.Header().Add("Accept-CH", strings.Join(vary, ", "))
+		w.Header().Add("Vary", strings.Join(vary, ", "))
+	}
+
+	// Select the network and CPU conditions to emulate
+	opts.Throttle, opts.Conditions = h.selectThrottle(r)
+	if vary := h.throttleVary(); len(vary) > 0 {
+		w.Header().Add("Vary", strings.Join(vary, ", "))
+	}
+
+	// Signed overrides take precedence over everything selected above
+	if overrides != nil {
+		overrides.apply(opts)
+	}
+	r = withRenderOptions(r, opts)
+
+	// Show the hashed key only, as its source holds cookie and header values
+	if h.CacheKeyHeader != "" {
+		w.Header().Set(h.CacheKeyHeader, h.getCacheKey(r))
+	}
+
+	// Check cache first, serving stale copies while they are refreshed in the background
+	cached := h.getCachedResponse(r)
+	if cached != nil {
+		now := time.Now()
+		if cached.fresh(now) {
+			h.metrics.cacheHits.Inc()
+			return h.serveCachedResponse(w, r, cached, cacheHit, requestStart)
+		}
+		if cached.staleWhileRevalidate(now) {
+			h.metrics.cacheStaleTotal.WithLabelValues("revalidate").Inc()
+			h.revalidate(r, opts, cached)
+			return h.serveCachedResponse(w, r, cached, cacheStale, requestStart)
+		}
+		h.setCacheStatus(w, r, cacheExpired, nil)
+	} else if h.CacheTTL > 0 && h.getCacheKey(r) != "" {
+		h.setCacheStatus(w, r, cacheMiss, nil)
 	}
 	
 	h.metrics.cacheMisses.Inc()
 
+	// Render the page, sharing renders in flight for the same cache key
+	result, err := h.renderCoalesced(r, opts, cached)
+	if err != nil || result.StatusCode >= 500 {
+		// Serve a stale copy rather than an error while rendering or upstream fails
+		if cached != nil && cached.staleIfError(time.Now()) {
+			h.metrics.cacheStaleTotal.WithLabelValues("error").Inc()
+			fields := []zap.Field{zap.String("path", r.URL.Path)}
+			if err != nil {
+				fields = append(fields, zap.Error(err))
+			} else {
+				fields = append(fields, zap.Int("status", result.StatusCode))
+			}
+			h.logger.Warn("render failed, serving stale response", fields...)
+			return h.serveCachedResponse(w, r, cached, cacheStale, requestStart)
+		}
+		if status, ok := renderErrorStatus(err); ok {
+			h.handleError(w, r, err, status)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	responseContent, responseHeaders, responseStatusCode := result.Content, result.Headers, result.StatusCode
+
+	// Answer conditional requests the rendered response satisfies
+	if notModified(r, responseHeaders) {
+		writeNotModified(w, responseHeaders)
+		h.metrics.requestsTotal.WithLabelValues(r.Method, fmt.Sprintf("%d", http.StatusNotModified)).Inc()
+		h.metrics.responseStatusCode.WithLabelValues(fmt.Sprintf("%d", http.StatusNotModified)).Inc()
+		return nil
+	}
+
+	// Set headers in the response, keeping the cache status of this response
+	// over the one upstream caches gave theirs
+	for key, values := range responseHeaders {
+		switch key {
+		case "X-Cache", "Age", "Cache-Status":
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+
+	// Set status code
+	w.WriteHeader(responseStatusCode)
+
+	// Write the content to the response
+	_, err = w.Write(responseContent)
+	if err != nil {
+		h.metrics.browserErrorsTotal.WithLabelValues("write_response").Inc()
+		return fmt.Errorf("failed to write response: %v", err)
+	}
+
+	// Record response metrics
+	responseTime := time.Since(requestStart)
+	h.metrics.requestsTotal.WithLabelValues(r.Method, fmt.Sprintf("%d", responseStatusCode)).Inc()
+	h.metrics.requestDuration.WithLabelValues(r.Method, fmt.Sprintf("%d", responseStatusCode)).Observe(responseTime.Seconds())
+	h.metrics.responseSize.WithLabelValues(r.Method, fmt.Sprintf("%d", responseStatusCode)).Observe(float64(len(responseContent)))
+	h.metrics.responseStatusCode.WithLabelValues(fmt.Sprintf("%d", responseStatusCode)).Inc()
+
+	h.logger.Info("request completed",
+		zap.Int("status", responseStatusCode),
+		zap.Int("content_length", len(responseContent)),
+		zap.Duration("response_time", responseTime),
+	)
+
+	return nil
+}
+
+// renderResult is the response produced by a render
+type renderResult struct {
+	Content    []byte
+	Headers    http.Header
+	StatusCode int
+
+	// Headers of the upstream response, for caching decisions
+	Upstream http.Header
+}
+
+// render loads the target of a request in a browser and produces the response
+func (h *HeadlessProxy) render(r *http.Request, opts *renderOptions) (*renderResult, error) {
+	h.activeRenders.Add(1)
+	defer h.activeRenders.Add(-1)
+
 	// Get a browser from the pool
 	browser := h.getBrowser()
 	if browser == nil {
 		h.metrics.browserErrorsTotal.WithLabelValues("get_browser").Inc()
-		return fmt.Errorf("failed to get browser from pool")
+		return nil, fmt.Errorf("failed to get browser from pool")
 	}
 
 	// Make sure to return the browser to the pool when done
 	defer h.returnBrowser(browser)
 
 	// Create a context with timeout
-	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(h.Timeout)*time.Second)
+	timeout := time.Duration(h.Timeout) * time.Second
+	if opts.Timeout > 0 {
+		timeout = opts.Timeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
 	defer cancel()
 
 	// Create the target URL by combining the upstream with the request path
-	targetURL := h.Upstream
-	if !strings.HasSuffix(targetURL, "/") && !strings.HasPrefix(r.URL.Path, "/") {
-		targetURL += "/"
-	}
-	targetURL += r.URL.Path
-	if r.URL.RawQuery != "" {
-		targetURL += "?" + r.URL.RawQuery
-	}
+	targetURL := h.targetURL(r)
 
 	h.logger.Info("proxying request",
 		zap.String("method", r.Method),
 		zap.String("url", targetURL),
 	)
 
+	// Route the render through an egress proxy
+	pageBrowser, egressProxy, releaseContext, err := h.proxyContext(browser, r)
+	if err != nil {
+		h.metrics.browserErrorsTotal.WithLabelValues("browser_context").Inc()
+		return nil, err
+	}
+	defer releaseContext()
+
 	// Create a new browser page
-	page, err := browser.Page(proto.TargetCreateTarget{})
+	page, err := pageBrowser.Page(proto.TargetCreateTarget{})
 	if err != nil {
 		h.metrics.browserErrorsTotal.WithLabelValues("create_page").Inc()
-		return fmt.Errorf("failed to create page: %v", err)
+		return nil, fmt.Errorf("failed to create page: %v", err)
 	}
 	defer func() {
 		err := page.Close()
@@ -267,13 +738,47 @@ func (h *HeadlessProxy) ServeHTTP(w http.ResponseWriter, r *http.Request, next c
 		}
 	}()
 
-	// Set user agent
+	// Set user agent and matching Client Hints
 	err = page.SetUserAgent(&proto.NetworkSetUserAgentOverride{
-		UserAgent: h.UserAgent,
+		UserAgent:         opts.UserAgent,
+		AcceptLanguage:    opts.AcceptLanguage,
+		UserAgentMetadata: opts.UserAgentMetadata,
 	})
 	if err != nil {
 		h.metrics.browserErrorsTotal.WithLabelValues("set_user_agent").Inc()
-		return fmt.Errorf("failed to set user agent: %v", err)
+		return nil, fmt.Errorf("failed to set user agent: %v", err)
+	}
+
+	// Emulate the selected device
+	if opts.Device != nil {
+		err = applyProfile(page, opts.Device)
+		if err != nil {
+			h.metrics.browserErrorsTotal.WithLabelValues("emulate_device").Inc()
+			return nil, err
+		}
+	}
+
+	// Emulate the locale, timezone and position
+	err = applyLocale(page, opts, targetURL)
+	if err != nil {
+		h.metrics.browserErrorsTotal.WithLabelValues("emulate_locale").Inc()
+		return nil, err
+	}
+
+	// Emulate the CSS media type and user preferences
+	err = applyMedia(page, opts)
+	if err != nil {
+		h.metrics.browserErrorsTotal.WithLabelValues("emulate_media").Inc()
+		return nil, err
+	}
+
+	// Throttle the network and CPU
+	if opts.Conditions != nil {
+		err = applyThrottle(page, opts.Conditions)
+		if err != nil {
+			h.metrics.browserErrorsTotal.WithLabelValues("throttle").Inc()
+			return nil, err
+		}
 	}
 
 	// Disable JavaScript if needed
@@ -292,24 +797,21 @@ func (h *HeadlessProxy) ServeHTTP(w http.ResponseWriter, r *http.Request, next c
 		`)
 		if err != nil {
 			h.metrics.browserErrorsTotal.WithLabelValues("disable_js").Inc()
-			return fmt.Errorf("failed to set JavaScript settings: %v", err)
+			return nil, fmt.Errorf("failed to set JavaScript settings: %v", err)
 		}
 	}
 
 	// Forward cookies if enabled
-	if h.ForwardCookies {
-		cookies := r.Cookies()
-		for _, cookie := range cookies {
-			err = page.SetCookies(&proto.NetworkCookieParam{
-				Name:   cookie.Name,
-				Value:  cookie.Value,
-				Domain: cookie.Domain,
-				Path:   cookie.Path,
-			})
-			if err != nil {
-				h.logger.Error("failed to set cookie", zap.Error(err))
-				h.metrics.browserErrorsTotal.WithLabelValues("set_cookie").Inc()
-			}
+	for _, cookie := range h.forwardedCookies(r) {
+		err = page.SetCookies(&proto.NetworkCookieParam{
+			Name:   cookie.Name,
+			Value:  cookie.Value,
+			Domain: cookie.Domain,
+			Path:   cookie.Path,
+		})
+		if err != nil {
+			h.logger.Error("failed to set cookie", zap.Error(err))
+			h.metrics.browserErrorsTotal.WithLabelValues("set_cookie").Inc()
 		}
 	}
 
@@ -317,6 +819,7 @@ func (h *HeadlessProxy) ServeHTTP(w http.ResponseWriter, r *http.Request, next c
 	var responseStatusCode int = http.StatusOK
 	responseHeaders := make(http.Header)
 	var responseContent []byte
+	var upstreamHeaders http.Header
 
 	// Start measuring browser render time
 	renderStart := time.Now()
@@ -328,8 +831,32 @@ func (h *HeadlessProxy) ServeHTTP(w http.ResponseWriter, r *http.Request, next c
 		router := page.HijackRequests()
 		defer router.Stop()
 
+		// Filter lists judge requests by the site of the rendered page
+		pageHost := ""
+		if u, err := url.Parse(targetURL); err == nil {
+			pageHost = u.Hostname()
+		}
+
 		// Intercept requests to modify headers
 		router.MustAdd("*", func(ctx *rod.Hijack) {
+			resourceType, requestURL := ctx.Request.Type(), ctx.Request.URL()
+
+			// Answer mocked requests without touching the network
+			if mock := h.matchMock(requestURL.String()); mock != nil {
+				h.metrics.mockedRequestsTotal.Inc()
+				mock.fulfill(ctx)
+				return
+			}
+
+			// Fail requests for blocked resource types and domains, and those on filter lists
+			mainFrame := ctx.Request.Event().FrameID == page.FrameID
+			if h.blocker.Blocked(resourceType, requestURL, mainFrame) ||
+				h.filterEngine != nil && h.filterEngine.Blocked(resourceType, requestURL, pageHost) {
+				h.metrics.blockedRequestsTotal.WithLabelValues(strings.ToLower(string(resourceType))).Inc()
+				ctx.Response.Fail(proto.NetworkErrorReasonBlockedByClient)
+				return
+			}
+
 			// Add forwarded headers
 			for _, header := range h.ForwardHeaders {
 				if value := r.Header.Get(header); value != "" {
@@ -343,18 +870,44 @@ func (h *HeadlessProxy) ServeHTTP(w http.ResponseWriter, r *http.Request, next c
 		
 		go router.Run()
 
+		// Answer proxy auth challenges; the router continues the paused requests
+		err = handleProxyAuth(ctx, page, egressProxy, false)
+		if err != nil {
+			h.metrics.browserErrorsTotal.WithLabelValues("proxy_auth").Inc()
+			return nil, err
+		}
+
+		// Record network activity if a HAR was requested
+		var har *harRecorder
+		if h.wantsHAR(r) {
+			har = newHARRecorder(page, h.HARMaxBodySize)
+			har.Start(ctx)
+			defer har.Stop()
+		}
+
+		// Collect console output and page-side errors
+		requestID := getRequestID(r)
+		pageErrors := newPageErrorCollector(h, page, requestID)
+		pageErrors.Start(ctx)
+		defer pageErrors.Stop()
+
+		// Record the upstream response of the document for caching decisions
+		document := newDocumentRecorder(page)
+		document.Start(ctx)
+		defer document.Stop()
+
 		// Navigate to the page
 		err = page.Context(ctx).Navigate(targetURL)
 		if err != nil {
 			h.metrics.browserErrorsTotal.WithLabelValues("navigate").Inc()
-			return fmt.Errorf("failed to navigate to %s: %v", targetURL, err)
+			return nil, fmt.Errorf("failed to navigate to %s: %v", targetURL, err)
 		}
 
 		// Wait for the page to load
 		err = page.WaitNavigation(proto.PageLifecycleEventNameDOMContentLoaded)
 		if err != nil {
 			h.metrics.browserErrorsTotal.WithLabelValues("wait_navigation").Inc()
-			return fmt.Errorf("failed to wait for navigation: %v", err)
+			return nil, fmt.Errorf("failed to wait for navigation: %v", err)
 		}
 
 		// Wait for network to be idle
@@ -364,12 +917,64 @@ func (h *HeadlessProxy) ServeHTTP(w http.ResponseWriter, r *http.Request, next c
 			h.metrics.browserErrorsTotal.WithLabelValues("wait_idle").Inc()
 		}
 
-		// Record browser render time
-		h.metrics.browserRenderTime.Observe(time.Since(renderStart).Seconds())
+		// Wait for the requested element to appear
+		if opts.WaitFor != "" {
+			_, err = page.Context(ctx).Element(opts.WaitFor)
+			if err != nil {
+				h.metrics.browserErrorsTotal.WithLabelValues("wait_selector").Inc()
+				return nil, fmt.Errorf("%w: waiting for %q: %v", ErrTimeout, opts.WaitFor, err)
+			}
+		}
+
+		// Record browser render time
+		renderTime := time.Since(renderStart)
+		h.metrics.browserRenderTime.Observe(renderTime.Seconds())
+
+		// Treat page-side errors as a failed render if configured
+		if h.FailOnJSError {
+			if msg, failed := pageErrors.FirstError(); failed {
+				h.metrics.browserErrorsTotal.WithLabelValues("js_error").Inc()
+				return nil, fmt.Errorf("%w: %s", ErrJSError, msg.Text)
+			}
+		}
+
+		// Audit the page before optimization rewrites its markup
+		var seo *seoReport
+		if opts.Format == "seo-report" {
+			seo, err = h.buildSEOReport(ctx, r, page, targetURL)
+			if err != nil {
+				h.metrics.browserErrorsTotal.WithLabelValues("seo_report").Inc()
+				return nil, err
+			}
+		}
+
+		// Compare against the raw upstream HTML, for diagnostics or as a sample
+		var diff *diffReport
+		if opts.Format == "diff-report" {
+			rendered, err := page.HTML()
+			if err != nil {
+				h.metrics.browserErrorsTotal.WithLabelValues("get_html").Inc()
+				return nil, fmt.Errorf("failed to get page HTML: %v", err)
+			}
+			diff, err = h.renderDiff(ctx, r, page, targetURL, rendered)
+			if err != nil {
+				h.metrics.browserErrorsTotal.WithLabelValues("diff_report").Inc()
+				return nil, err
+			}
+			h.diffSampler.record(pathPrefix(r.URL.Path, h.DiffPrefixDepth), diff)
+		} else if h.diffSampler.ShouldSample() {
+			if rendered, err := page.HTML(); err == nil {
+				h.diffSampler.Enqueue(r, targetURL, rendered)
+			}
+		}
 
 		// Collect performance metrics if available
-		if perfMetrics, err := h.monitor.MonitorPagePerformance(page); err == nil {
+		perfMetrics, err := h.monitor.MonitorPagePerformance(page)
+		if err == nil {
 			h.logger.Debug("page performance metrics", zap.Any("metrics", perfMetrics))
+		} else if opts.Format == "performance" {
+			h.metrics.browserErrorsTotal.WithLabelValues("performance").Inc()
+			return nil, err
 		}
 
 		// Optimize the page content if enabled
@@ -385,19 +990,66 @@ func (h *HeadlessProxy) ServeHTTP(w http.ResponseWriter, r *http.Request, next c
 		content, err := page.HTML()
 		if err != nil {
 			h.metrics.browserErrorsTotal.WithLabelValues("get_html").Inc()
-			return fmt.Errorf("failed to get page HTML: %v", err)
+			return nil, fmt.Errorf("failed to get page HTML: %v", err)
 		}
 		responseContent = []byte(content)
 
-		// Set content type header
-		responseHeaders.Set("Content-Type", "text/html; charset=utf-8")
+		// Emit the HAR capture if one was recorded
+		var harDoc *harLog
+		if har != nil {
+			harDoc = har.Build(targetURL)
+			if h.harDebugRequested(r) {
+				h.writeHARFile(requestID, harDoc)
+			}
+		}
+
+		// Produce the negotiated output format
+		switch opts.Format {
+		case "html":
+		case "har":
+			responseContent, err = json.Marshal(harDoc)
+		case "seo-report":
+			responseContent, err = json.Marshal(seo)
+		case "diff-report":
+			responseContent, err = json.Marshal(diff)
+		case "performance":
+			responseContent, err = json.Marshal(performanceReport{
+				URL:          targetURL,
+				Profile:      opts.Profile,
+				Throttle:     opts.Throttle,
+				Conditions:   opts.Conditions,
+				RenderTimeMS: renderTime.Milliseconds(),
+				Performance:  perfMetrics,
+			})
+		default:
+			responseContent, err = h.renderOutput(page, opts.Format)
+		}
+		if err != nil {
+			h.metrics.browserErrorsTotal.WithLabelValues("render_output").Inc()
+			return nil, fmt.Errorf("failed to produce %s output: %v", opts.Format, err)
+		}
+		responseHeaders.Set("Content-Type", outputFormats[opts.Format])
+
+		// The rendered page answers with the status of its upstream document
+		var documentStatus int
+		documentStatus, upstreamHeaders = document.Response()
+		if documentStatus >= 200 && documentStatus != http.StatusNotModified {
+			responseStatusCode = documentStatus
+		}
 
 	case http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch:
+		// Answer proxy auth challenges
+		err = handleProxyAuth(ctx, page, egressProxy, true)
+		if err != nil {
+			h.metrics.browserErrorsTotal.WithLabelValues("proxy_auth").Inc()
+			return nil, err
+		}
+
 		// Read request body
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
 			h.metrics.browserErrorsTotal.WithLabelValues("read_body").Inc()
-			return fmt.Errorf("failed to read request body: %v", err)
+			return nil, fmt.Errorf("failed to read request body: %v", err)
 		}
 
 		// Prepare headers
@@ -449,7 +1101,7 @@ func (h *HeadlessProxy) ServeHTTP(w http.ResponseWriter, r *http.Request, next c
 		err = page.Eval(fetchScript).Unmarshal(&result)
 		if err != nil {
 			h.metrics.browserErrorsTotal.WithLabelValues("fetch_eval").Inc()
-			return fmt.Errorf("failed to execute fetch: %v", err)
+			return nil, fmt.Errorf("failed to execute fetch: %v", err)
 		}
 
 		// Record browser render time
@@ -459,9 +1111,11 @@ func (h *HeadlessProxy) ServeHTTP(w http.ResponseWriter, r *http.Request, next c
 		if errorMsg, ok := result["error"].(string); ok {
 			h.logger.Error("fetch API error", zap.String("error", errorMsg))
 			h.metrics.browserErrorsTotal.WithLabelValues("fetch_api").Inc()
-			w.WriteHeader(http.StatusBadGateway)
-			_, err = w.Write([]byte("Error communicating with upstream server"))
-			return err
+			return &renderResult{
+				Content:    []byte("Error communicating with upstream server"),
+				Headers:    responseHeaders,
+				StatusCode: http.StatusBadGateway,
+			}, nil
 		}
 
 		// Set response headers
@@ -471,6 +1125,8 @@ func (h *HeadlessProxy) ServeHTTP(w http.ResponseWriter, r *http.Request, next c
 			}
 		}
 
+		upstreamHeaders = responseHeaders
+
 		// Set status code
 		if status, ok := result["status"].(float64); ok {
 			responseStatusCode = int(status)
@@ -531,40 +1187,31 @@ func (h *HeadlessProxy) ServeHTTP(w http.ResponseWriter, r *http.Request, next c
 		}
 	}
 
-	// Cache the response
-	h.setCachedResponse(r, responseContent, responseHeaders, responseStatusCode)
-
-	// Set headers in the response
-	for key, values := range responseHeaders {
-		for _, value := range values {
-			w.Header().Add(key, value)
-		}
+	result := &renderResult{
+		Content:    responseContent,
+		Headers:    responseHeaders,
+		StatusCode: responseStatusCode,
+		Upstream:   upstreamHeaders,
 	}
 
-	// Set status code
-	w.WriteHeader(responseStatusCode)
-
-	// Write the content to the response
-	_, err = w.Write(responseContent)
-	if err != nil {
-		h.metrics.browserErrorsTotal.WithLabelValues("write_response").Inc()
-		return fmt.Errorf("failed to write response: %v", err)
+	// Let clients and the cache revalidate the rendered body
+	if r.Method == http.MethodGet {
+		result.addValidators(time.Now())
 	}
+	return result, nil
+}
 
-	// Record response metrics
-	responseTime := time.Since(requestStart)
-	h.metrics.requestsTotal.WithLabelValues(r.Method, fmt.Sprintf("%d", responseStatusCode)).Inc()
-	h.metrics.requestDuration.WithLabelValues(r.Method, fmt.Sprintf("%d", responseStatusCode)).Observe(responseTime.Seconds())
-	h.metrics.responseSize.WithLabelValues(r.Method, fmt.Sprintf("%d", responseStatusCode)).Observe(float64(len(responseContent)))
-	h.metrics.responseStatusCode.WithLabelValues(fmt.Sprintf("%d", responseStatusCode)).Inc()
-
-	h.logger.Info("request completed",
-		zap.Int("status", responseStatusCode),
-		zap.Int("content_length", len(responseContent)),
-		zap.Duration("response_time", responseTime),
-	)
-
-	return nil
+// targetURL combines the upstream with the path and query of a request
+func (h *HeadlessProxy) targetURL(r *http.Request) string {
+	targetURL := h.Upstream
+	if !strings.HasSuffix(targetURL, "/") && !strings.HasPrefix(r.URL.Path, "/") {
+		targetURL += "/"
+	}
+	targetURL += r.URL.Path
+	if r.URL.RawQuery != "" {
+		targetURL += "?" + r.URL.RawQuery
+	}
+	return targetURL
 }
 
 // CaddyModule returns the Caddy module information.
@@ -575,49 +1222,6 @@ func (HeadlessProxy) CaddyModule() caddy.ModuleInfo {
 	}
 }
 
-// Provision sets up the module.
-func (h *HeadlessProxy) Provision(ctx caddy.Context) error {
-	// Set default values
-	if h.Timeout <= 0 {
-		h.Timeout = 30
-	}
-
-	if h.UserAgent == "" {
-		h.UserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36"
-	}
-
-	// Enable JS by default
-	if !h.EnableJS {
-		h.EnableJS = true
-	}
-
-	// Set default max browsers
-	if h.MaxBrowsers <= 0 {
-		h.MaxBrowsers = 5
-	}
-
-	// Initialize cache if caching is enabled
-	if h.CacheTTL > 0 {
-		h.cache = make(map[string]cacheEntry)
-	}
-
-	// Get a logger
-	h.logger = ctx.Logger().Named("headless_proxy").With(
-		zap.String("upstream", h.Upstream),
-	)
-
-	// Initialize browser pool
-	h.browserPool = make([]*rod.Browser, 0, h.MaxBrowsers)
-	h.initBrowserPool()
-
-	h.logger.Info("headless proxy module initialized",
-		zap.Int("max_browsers", h.MaxBrowsers),
-		zap.Int("cache_ttl", h.CacheTTL),
-		zap.Bool("optimize_resources", h.OptimizeResources),
-	)
-	return nil
-}
-
 // initBrowserPool initializes the browser pool with one browser
 func (h *HeadlessProxy) initBrowserPool() {
 	// Start with one browser in the pool
@@ -630,7 +1234,7 @@ func (h *HeadlessProxy) initBrowserPool() {
 
 // createBrowser creates a new browser instance
 func (h *HeadlessProxy) createBrowser() *rod.Browser {
-	launcherURL := launcher.New().
+	l := launcher.New().
 		Headless(true).
 		NoSandbox(true).
 		Devtools(false).
@@ -640,8 +1244,19 @@ func (h *HeadlessProxy) createBrowser() *rod.Browser {
 		Env("--no-first-run").
 		Env("--no-zygote").
 		Env("--single-process").
-		Env("--disable-extensions").
-		MustLaunch()
+		Env("--disable-extensions")
+
+	// Launch the browser behind its egress proxy
+	var egressProxy *browserProxy
+	if h.proxyPool != nil && h.BrowserProxyScope == "browser" {
+		egressProxy = h.proxyPool.Select("")
+		l = l.Proxy(egressProxy.server)
+	}
+	launcherURL, err := l.Launch()
+	if err != nil {
+		h.logger.Error("failed to launch browser", zap.Error(err))
+		return nil
+	}
 
 	browser := rod.New().
 		ControlURL(launcherURL).
@@ -653,6 +1268,12 @@ func (h *HeadlessProxy) createBrowser() *rod.Browser {
 		return nil
 	}
 
+	h.detectBrowserIdentity(browser)
+
+	if egressProxy != nil {
+		h.proxyPool.Assign(browser, egressProxy)
+	}
+
 	return browser
 }
 
@@ -677,8 +1298,11 @@ func (h *HeadlessProxy) returnBrowser(browser *rod.Browser) {
 	h.browserPoolLock.Lock()
 	defer h.browserPoolLock.Unlock()
 
-	// If the pool is full, close the browser
-	if len(h.browserPool) >= h.MaxBrowsers {
+	// If the pool is full or the browser's proxy went down, close the browser
+	if len(h.browserPool) >= h.MaxBrowsers || h.proxyPool != nil && !h.proxyPool.BrowserHealthy(browser) {
+		if h.proxyPool != nil {
+			h.proxyPool.Release(browser)
+		}
 		go func() {
 			err := browser.Close()
 			if err != nil {
@@ -692,24 +1316,6 @@ func (h *HeadlessProxy) returnBrowser(browser *rod.Browser) {
 	h.browserPool = append(h.browserPool, browser)
 }
 
-// Cleanup cleans up the module's resources.
-func (h *HeadlessProxy) Cleanup() error {
-	h.browserPoolLock.Lock()
-	defer h.browserPoolLock.Unlock()
-
-	// Close all browsers in the pool
-	for _, browser := range h.browserPool {
-		err := browser.Close()
-		if err != nil {
-			h.logger.Error("failed to close browser during cleanup", zap.Error(err))
-		}
-	}
-
-	h.browserPool = nil
-	h.logger.Info("all browsers closed, cleanup complete")
-	return nil
-}
-
 // Validate ensures the module's configuration is valid.
 func (h *HeadlessProxy) Validate() error {
 	if h.Upstream == "" {
@@ -722,720 +1328,733 @@ func (h *HeadlessProxy) Validate() error {
 		return fmt.Errorf("invalid upstream URL: %v", err)
 	}
 
-	return nil
-}
-
-// getCacheKey generates a cache key for a request
-func (h *HeadlessProxy) getCacheKey(r *http.Request) string {
-	// Create a hash of the request details
-	hasher := sha256.New()
-	hasher.Write([]byte(r.Method))
-	hasher.Write([]byte(r.URL.Path))
-	hasher.Write([]byte(r.URL.RawQuery))
-
-	// Include relevant headers in the cache key
-	for _, header := range h.ForwardHeaders {
-		if value := r.Header.Get(header); value != "" {
-			hasher.Write([]byte(header + ":" + value))
+	if _, ok := outputFormats[h.Output]; h.Output != "" && !ok {
+		return fmt.Errorf("unsupported output format: %s", h.Output)
+	}
+	if h.DefaultProfile != "" {
+		if _, ok := h.lookupProfile(h.DefaultProfile); !ok {
+			return fmt.Errorf("unknown default_profile: %s", h.DefaultProfile)
 		}
 	}
-
-	// Include cookies if they're being forwarded
-	if h.ForwardCookies {
-		for _, cookie := range r.Cookies() {
-			hasher.Write([]byte(cookie.Name + "=" + cookie.Value))
+	for name, profile := range h.Profiles {
+		if profile.Width <= 0 || profile.Height <= 0 {
+			return fmt.Errorf("profile %s: viewport width and height are required", name)
 		}
 	}
 
-	return hex.EncodeToString(hasher.Sum(nil))
-}
-
-// getCachedResponse retrieves a cached response if available
-func (h *HeadlessProxy) getCachedResponse(r *http.Request) ([]byte, http.Header, int, bool) {
-	if h.CacheTTL <= 0 {
-		return nil, nil, 0, false
+	if h.Timezone != "" {
+		if _, err := time.LoadLocation(h.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone: %v", err)
+		}
 	}
 
-	key := h.getCacheKey(r)
-	h.cacheLock.RLock()
-	defer h.cacheLock.RUnlock()
-
-	if entry, ok := h.cache[key]; ok {
-		if time.Now().Before(entry.Expiration) {
-			return entry.Content, entry.Headers, entry.StatusCode, true
+	for feature, value := range map[string]string{
+		"prefers-color-scheme":   h.ColorScheme,
+		"prefers-reduced-motion": h.ReducedMotion,
+		"forced-colors":          h.ForcedColors,
+		"media":                  h.Media,
+	} {
+		if value != "" && !validMediaFeature(feature, value) {
+			return fmt.Errorf("invalid %s value: %s", feature, value)
 		}
-		// Remove expired entry
-		delete(h.cache, key)
 	}
-	return nil, nil, 0, false
-}
 
-// setCachedResponse caches a response
-func (h *HeadlessProxy) setCachedResponse(r *http.Request, content []byte, headers http.Header, statusCode int) {
-	if h.CacheTTL <= 0 {
-		return
+	for _, name := range h.BlockResourceTypes {
+		if _, ok := resourceTypes[strings.ToLower(name)]; !ok {
+			return fmt.Errorf("unknown resource type: %s", name)
+		}
 	}
 
-	key := h.getCacheKey(r)
-	h.cacheLock.Lock()
-	defer h.cacheLock.Unlock()
-
-	// Clean up old entries if cache is getting too large (more than 1000 entries)
-	if len(h.cache) > 1000 {
-		now := time.Now()
-		for k, v := range h.cache {
-			if now.After(v.Expiration) {
-				delete(h.cache, k)
-			}
+	for _, mock := range h.Mocks {
+		if mock.Pattern == "" {
+			return fmt.Errorf("mock: pattern is required")
+		}
+		if mock.File != "" && mock.Body != "" {
+			return fmt.Errorf("mock %s: file and body are mutually exclusive", mock.Pattern)
+		}
+		if mock.Status != 0 && (mock.Status < 100 || mock.Status > 599) {
+			return fmt.Errorf("mock %s: invalid status %d", mock.Pattern, mock.Status)
 		}
 	}
 
-	h.cache[key] = cacheEntry{
-		Content:    content,
-		Headers:    headers,
-		StatusCode: statusCode,
-		Expiration: time.Now().Add(time.Duration(h.CacheTTL) * time.Second),
+	for _, rule := range h.CacheRules {
+		if rule.Action != "force" && rule.Action != "forbid" {
+			return fmt.Errorf("cache rule %s: action must be force or forbid", rule.Path)
+		}
 	}
-}
-
-// ServeHTTP implements the caddyhttp.MiddlewareHandler interface.
-func (h *HeadlessProxy) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
-	requestStart := time.Now()
 
-	// Check cache first
-	if content, headers, statusCode, found := h.getCachedResponse(r); found {
-		h.logger.Info("serving cached response",
-			zap.String("path", r.URL.Path),
-			zap.Int("status", statusCode),
-			zap.Duration("response_time", time.Since(requestStart)),
-		)
-
-		for key, values := range headers {
-			for _, value := range values {
-				w.Header().Add(key, value)
-			}
+	for _, raw := range h.BrowserProxies {
+		if _, err := parseBrowserProxy(raw); err != nil {
+			return err
 		}
-
-		w.WriteHeader(statusCode)
-		_, err := w.Write(content)
-		return err
 	}
-
-	// Get a browser from the pool
-	browser := h.getBrowser()
-	if browser == nil {
-		return fmt.Errorf("failed to get browser from pool")
+	if h.BrowserProxyScope != "" && h.BrowserProxyScope != "browser" && h.BrowserProxyScope != "context" {
+		return fmt.Errorf("browser_proxy_scope must be browser or context")
+	}
+	if h.BrowserProxySelection != "" && h.BrowserProxySelection != "round_robin" && h.BrowserProxySelection != "sticky" {
+		return fmt.Errorf("browser_proxy_selection must be round_robin or sticky")
 	}
 
-	// Make sure to return the browser to the pool when done
-	defer h.returnBrowser(browser)
-
-	// Create a context with timeout
-	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(h.Timeout)*time.Second)
-	defer cancel()
-
-	// Create the target URL by combining the upstream with the request path
-	targetURL := h.Upstream
-	if !strings.HasSuffix(targetURL, "/") && !strings.HasPrefix(r.URL.Path, "/") {
-		targetURL += "/"
+	if h.Throttle != "" {
+		if _, ok := h.lookupThrottle(h.Throttle); !ok {
+			return fmt.Errorf("unknown throttle profile: %s", h.Throttle)
+		}
 	}
-	targetURL += r.URL.Path
-	if r.URL.RawQuery != "" {
-		targetURL += "?" + r.URL.RawQuery
+	for name, throttle := range h.Throttles {
+		if throttle.Latency < 0 || throttle.Download < 0 || throttle.Upload < 0 || throttle.CPU < 0 {
+			return fmt.Errorf("throttle profile %s: values must not be negative", name)
+		}
 	}
 
-	h.logger.Info("proxying request",
-		zap.String("method", r.Method),
-		zap.String("url", targetURL),
-	)
-
-	// Create a new browser page
-	page, err := browser.Page(proto.TargetCreateTarget{})
-	if err != nil {
-		return fmt.Errorf("failed to create page: %v", err)
+	if h.DiffSampleRate < 0 || h.DiffSampleRate > 1 {
+		return fmt.Errorf("diff_sample_rate must be between 0 and 1")
 	}
-	defer func() {
-		err := page.Close()
-		if err != nil {
-			h.logger.Error("failed to close page", zap.Error(err))
+	if h.WarmRefresh != "" && h.WarmRefresh != "lastmod" && h.WarmRefresh != "always" {
+		return fmt.Errorf("warm_refresh must be lastmod or always")
+	}
+	if h.WarmPoolShare < 0 || h.WarmPoolShare > 1 {
+		return fmt.Errorf("warm_pool_share must be between 0 and 1")
+	}
+	for _, format := range h.Formats {
+		if _, ok := outputFormats[format]; !ok {
+			return fmt.Errorf("unsupported output format: %s", format)
 		}
-	}()
-
-	// Set user agent
-	err = page.SetUserAgent(&proto.NetworkSetUserAgentOverride{
-		UserAgent: h.UserAgent,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to set user agent: %v", err)
 	}
 
-	// Disable JavaScript if needed
-	if !h.EnableJS {
-		err = page.EvalOnNewDocument(`
-			Object.defineProperty(window, 'navigator', {
-				value: new Proxy(navigator, {
-					has: (target, key) => (key === 'plugins' || key === 'languages') ? false : key in target,
-					get: (target, key) => 
-						key === 'plugins' ? { length: 0 } : 
-						key === 'languages' ? ['en-US'] : 
-						key === 'userAgent' ? '` + h.UserAgent + `' : 
-						target[key]
-				})
-			});
-		`)
-		if err != nil {
-			return fmt.Errorf("failed to set JavaScript settings: %v", err)
+	return nil
+}
+
+// UnmarshalCaddyfile implements caddyfile.Unmarshaler.
+func (h *HeadlessProxy) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		if !d.NextArg() {
+			return d.ArgErr()
 		}
-	}
+		h.Upstream = d.Val()
 
-	// Forward cookies if enabled
-	if h.ForwardCookies {
-		cookies := r.Cookies()
-		for _, cookie := range cookies {
-			err = page.SetCookies(&proto.NetworkCookieParam{
-				Name:   cookie.Name,
-				Value:  cookie.Value,
-				Domain: cookie.Domain,
-				Path:   cookie.Path,
-			})
-			if err != nil {
-				h.logger.Error("failed to set cookie", zap.Error(err))
-			}
+		if d.NextArg() {
+			return d.ArgErr()
 		}
-	}
 
-	// Set up response capture
-	var responseStatusCode int = http.StatusOK
-	responseHeaders := make(http.Header)
-	var responseContent []byte
+		for d.NextBlock(0) {
+			switch d.Val() {
+			case "timeout":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				var err error
+				h.Timeout, err = parseInt(d.Val())
+				if err != nil {
+					return fmt.Errorf("invalid timeout value: %v", err)
+				}
 
-	// Handle different HTTP methods
-	switch r.Method {
-	case http.MethodGet:
-		// Navigate to the page
-		router := page.HijackRequests()
-		defer router.Stop()
-
-		// Intercept requests to modify headers
-				// Intercept requests to modify headers
-		router.MustAdd("*", func(ctx *rod.Hijack) {
-			// Add forwarded headers
-			for _, header := range h.ForwardHeaders {
-				if value := r.Header.Get(header); value != "" {
-					ctx.Request.SetHeader(header, value)
+			case "user_agent":
+				if !d.NextArg() {
+					return d.ArgErr()
 				}
-			}
-			
-			// Continue with the request
-			ctx.ContinueRequest(&proto.FetchContinueRequest{})
-		})
-		
-		go router.Run()
-
-		// Navigate to the page
-		err = page.Context(ctx).Navigate(targetURL)
-		if err != nil {
-			return fmt.Errorf("failed to navigate to %s: %v", targetURL, err)
-		}
-
-		// Wait for the page to load
-		err = page.WaitNavigation(proto.PageLifecycleEventNameDOMContentLoaded)
-		if err != nil {
-			return fmt.Errorf("failed to wait for navigation: %v", err)
-		}
-
-		// Wait for network to be idle
-		err = page.WaitIdle(time.Second * 2)
-		if err != nil {
-			h.logger.Warn("timeout waiting for network idle", zap.Error(err))
-		}
-
-		// Get the page content
-		if h.OptimizeResources {
-			// Optimize the page content
-			err = h.optimizePage(page)
-			if err != nil {
-				h.logger.Error("failed to optimize page", zap.Error(err))
-			}
-		}
-
-		// Get the final HTML content
-		content, err := page.HTML()
-		if err != nil {
-			return fmt.Errorf("failed to get page HTML: %v", err)
-		}
-		responseContent = []byte(content)
-
-		// Set content type header
-		responseHeaders.Set("Content-Type", "text/html; charset=utf-8")
-
-	case http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch:
-		// Read request body
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			return fmt.Errorf("failed to read request body: %v", err)
-		}
-
-		// Prepare headers
-		headers := map[string]string{
-			"Content-Type": r.Header.Get("Content-Type"),
-		}
-
-		// Add forwarded headers
-		for _, header := range h.ForwardHeaders {
-			if value := r.Header.Get(header); value != "" {
-				headers[header] = value
-			}
-		}
+				h.UserAgent = d.Val()
 
-		// Execute fetch API to make the request
-		fetchScript := fmt.Sprintf(`
-			(async () => {
-				try {
-					const response = await fetch('%s', {
-						method: '%s',
-						headers: %s,
-						body: %s,
-						credentials: 'include',
-						redirect: 'follow'
-					});
-					
-					const text = await response.text();
-					const headers = {};
-					response.headers.forEach((value, key) => {
-						headers[key] = value;
-					});
-					
-					return {
-						status: response.status,
-						statusText: response.statusText,
-						headers: headers,
-						body: text
-					};
-				} catch (error) {
-					return {
-						error: error.toString(),
-						status: 500
-					};
+			case "forward_user_agent":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				var err error
+				h.ForwardUserAgent, err = parseBool(d.Val())
+				if err != nil {
+					return fmt.Errorf("invalid forward_user_agent value: %v", err)
 				}
-			})()
-		`, targetURL, r.Method, toJSONString(headers), toJSONString(string(body)))
-
-		var result map[string]interface{}
-		err = page.Eval(fetchScript).Unmarshal(&result)
-		if err != nil {
-			return fmt.Errorf("failed to execute fetch: %v", err)
-		}
-
-		// Check for errors
-		if errorMsg, ok := result["error"].(string); ok {
-			h.logger.Error("fetch API error", zap.String("error", errorMsg))
-			w.WriteHeader(http.StatusBadGateway)
-			_, err = w.Write([]byte("Error communicating with upstream server"))
-			return err
-		}
-
-		// Set response headers
-		if headersMap, ok := result["headers"].(map[string]interface{}); ok {
-			for key, value := range headersMap {
-				responseHeaders.Set(key, fmt.Sprintf("%v", value))
-			}
-		}
-
-		// Set status code
-		if status, ok := result["status"].(float64); ok {
-			responseStatusCode = int(status)
-		}
-
-		// Set response body
-		if body, ok := result["body"].(string); ok {
-			responseContent = []byte(body)
-		}
 
-	default:
-		// For other methods, return method not allowed
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		_, err := w.Write([]byte("Method not allowed"))
-		return err
-	}
+			case "enable_js":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				var err error
+				h.EnableJS, err = parseBool(d.Val())
+				if err != nil {
+					return fmt.Errorf("invalid enable_js value: %v", err)
+				}
 
-	// Get cookies from the page and set them in the response
-	if h.ForwardCookies {
-		pageCookies, err := page.Cookies([]string{})
-		if err == nil {
-			for _, cookie := range pageCookies {
-				cookieStr := fmt.Sprintf("%s=%s", cookie.Name, cookie.Value)
-				if cookie.Path != "" {
-					cookieStr += "; Path=" + cookie.Path
+			case "forward_cookies":
+				if !d.NextArg() {
+					return d.ArgErr()
 				}
-				if cookie.Domain != "" {
-					cookieStr += "; Domain=" + cookie.Domain
+				var err error
+				h.ForwardCookies, err = parseBool(d.Val())
+				if err != nil {
+					return fmt.Errorf("invalid forward_cookies value: %v", err)
 				}
-				if cookie.Expires != 0 {
-					expTime := time.Unix(int64(cookie.Expires), 0)
-					cookieStr += "; Expires=" + expTime.Format(time.RFC1123)
+
+			case "forward_headers":
+				var headers []string
+				for d.NextArg() {
+					headers = append(headers, d.Val())
 				}
-				if cookie.Secure {
-					cookieStr += "; Secure"
+				h.ForwardHeaders = headers
+
+			case "cache_ttl":
+				if !d.NextArg() {
+					return d.ArgErr()
 				}
-				if cookie.HTTPOnly {
-					cookieStr += "; HttpOnly"
+				var err error
+				h.CacheTTL, err = parseInt(d.Val())
+				if err != nil {
+					return fmt.Errorf("invalid cache_ttl value: %v", err)
 				}
-				responseHeaders.Add("Set-Cookie", cookieStr)
-			}
-		}
-	}
 
-	// Cache the response
-	h.setCachedResponse(r, responseContent, responseHeaders, responseStatusCode)
+			case "cache_ttl_cap":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				var err error
+				h.CacheTTLCap, err = parseBool(d.Val())
+				if err != nil {
+					return fmt.Errorf("invalid cache_ttl_cap value: %v", err)
+				}
 
-	// Set headers in the response
-	for key, values := range responseHeaders {
-		for _, value := range values {
-			w.Header().Add(key, value)
-		}
-	}
+			case "cache_rule":
+				args := d.RemainingArgs()
+				if len(args) < 2 || len(args) > 3 {
+					return d.ArgErr()
+				}
+				rule := CacheRule{Path: args[0], Action: args[1]}
+				if len(args) == 3 {
+					var err error
+					rule.TTL, err = parseInt(args[2])
+					if err != nil {
+						return fmt.Errorf("invalid cache_rule ttl: %v", err)
+					}
+				}
+				h.CacheRules = append(h.CacheRules, rule)
 
-	// Set status code
-	w.WriteHeader(responseStatusCode)
+			case "cache_key":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.CacheKey = d.Val()
 
-	// Write the content to the response
-	_, err = w.Write(responseContent)
-	if err != nil {
-		return fmt.Errorf("failed to write response: %v", err)
-	}
+			case "cache_key_query_allow":
+				h.CacheKeyQueryAllow = append(h.CacheKeyQueryAllow, d.RemainingArgs()...)
 
-	h.logger.Info("request completed",
-		zap.Int("status", responseStatusCode),
-		zap.Int("content_length", len(responseContent)),
-		zap.Duration("response_time", time.Since(requestStart)),
-	)
+			case "cache_key_query_deny":
+				h.CacheKeyQueryDeny = append(h.CacheKeyQueryDeny, d.RemainingArgs()...)
 
-	return nil
-}
+			case "cache_key_cookies":
+				h.CacheKeyCookies = append(h.CacheKeyCookies, d.RemainingArgs()...)
 
-// optimizePage optimizes the page content by removing unnecessary elements and minifying content
-func (h *HeadlessProxy) optimizePage(page *rod.Page) error {
-	// Remove unnecessary elements and scripts
-	optimizeScript := `
-	(() => {
-		// Remove unnecessary elements
-		const removeSelectors = [
-			'script:not([type="application/ld+json"])',  // Remove most scripts
-			'link[rel="preload"]',                       // Remove preload links
-			'style:empty',                               // Remove empty style tags
-			'link[rel="prefetch"]',                      // Remove prefetch links
-			'meta[name="robots"]',                       // Remove robots meta
-			'meta[name="googlebot"]',                    // Remove googlebot meta
-			'meta[name="generator"]',                    // Remove generator meta
-			'[style="display:none"]:not(noscript)',      // Remove hidden elements
-			'[hidden]',                                  // Remove hidden elements
-			'iframe[style*="display: none"]',            // Remove hidden iframes
-			'comment()',                                 // Remove HTML comments
-		];
-
-		// Remove elements by selector
-		removeSelectors.forEach(selector => {
-			try {
-				document.querySelectorAll(selector).forEach(el => el.remove());
-			} catch (e) {
-				// Ignore errors for comment() selector
-			}
-		});
-
-		// Minify inline CSS
-		document.querySelectorAll('style').forEach(style => {
-			if (style.textContent) {
-				style.textContent = style.textContent
-					.replace(/\s+/g, ' ')
-					.replace(/\/\*[\s\S]*?\*\//g, '')
-					.replace(/\s*([{}:;,])\s*/g, '$1')
-					.replace(/;\s*}/g, '}');
-			}
-		});
-
-		// Minify inline attributes
-		document.querySelectorAll('[style]').forEach(el => {
-			if (el.getAttribute('style')) {
-				el.setAttribute('style', 
-					el.getAttribute('style')
-						.replace(/\s+/g, ' ')
-						.replace(/:\s+/g, ':')
-						.replace(/;\s+/g, ';')
-						.trim()
-				);
-			}
-		});
+			case "cache_key_header":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.CacheKeyHeader = d.Val()
 
-		// Remove data-* attributes except for a few important ones
-		document.querySelectorAll('*').forEach(el => {
-			Array.from(el.attributes).forEach(attr => {
-				if (attr.name.startsWith('data-') && 
-					!['data-id', 'data-src', 'data-href'].includes(attr.name)) {
-					el.removeAttribute(attr.name);
+			case "cache_max_size":
+				if !d.NextArg() {
+					return d.ArgErr()
 				}
-			});
-		});
-
-		// Optimize images if enabled
-		const optimizeImages = ${h.CompressImages};
-		if (optimizeImages) {
-			document.querySelectorAll('img').forEach(img => {
-				// Add loading="lazy" to images
-				if (!img.hasAttribute('loading')) {
-					img.setAttribute('loading', 'lazy');
-				}
-				
-				// Remove srcset for simplicity
-				img.removeAttribute('srcset');
-				
-				// Ensure alt attribute exists
-				if (!img.hasAttribute('alt')) {
-					img.setAttribute('alt', '');
+				var err error
+				h.CacheMaxSize, err = strconv.ParseInt(d.Val(), 10, 64)
+				if err != nil {
+					return fmt.Errorf("invalid cache_max_size value: %v", err)
 				}
-			});
-		}
-
-		return true;
-	})();
-	`
-
-	_, err := page.Eval(optimizeScript)
-	return err
-}
 
-// Helper function to convert a value to a JSON string
-func toJSONString(v interface{}) string {
-	bytes, err := json.Marshal(v)
-	if err != nil {
-		return "null"
-	}
-	return string(bytes)
-}
-
-// parseCaddyfile parses the Caddyfile directive.
-func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
-	var hp HeadlessProxy
-
-	for h.Next() {
-		if !h.NextArg() {
-			return nil, h.ArgErr()
-		}
-		hp.Upstream = h.Val()
+			case "cache_store":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.CacheStore = d.Val()
 
-		if h.NextArg() {
-			return nil, h.ArgErr()
-		}
+			case "cache_dir":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.CacheDir = d.Val()
 
-		for h.NextBlock(0) {
-			switch h.Val() {
-			case "timeout":
-				if !h.NextArg() {
-					return nil, h.ArgErr()
+			case "stale_while_revalidate":
+				if !d.NextArg() {
+					return d.ArgErr()
 				}
 				var err error
-				hp.Timeout, err = h.IntVal()
+				h.StaleWhileRevalidate, err = parseInt(d.Val())
 				if err != nil {
-					return nil, h.Errf("invalid timeout value: %v", err)
+					return fmt.Errorf("invalid stale_while_revalidate value: %v", err)
 				}
 
-			case "user_agent":
-				if !h.NextArg() {
-					return nil, h.ArgErr()
+			case "stale_if_error":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				var err error
+				h.StaleIfError, err = parseInt(d.Val())
+				if err != nil {
+					return fmt.Errorf("invalid stale_if_error value: %v", err)
 				}
-				hp.UserAgent = h.Val()
 
-			case "enable_js":
-				if !h.NextArg() {
-					return nil, h.ArgErr()
+			case "coalesce_timeout":
+				if !d.NextArg() {
+					return d.ArgErr()
 				}
 				var err error
-				hp.EnableJS, err = h.BoolVal()
+				h.CoalesceTimeout, err = parseInt(d.Val())
 				if err != nil {
-					return nil, h.Errf("invalid enable_js value: %v", err)
+					return fmt.Errorf("invalid coalesce_timeout value: %v", err)
 				}
 
-			case "forward_cookies":
-				if !h.NextArg() {
-					return nil, h.ArgErr()
+			case "warm_sitemap":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				h.WarmSitemaps = append(h.WarmSitemaps, args...)
+
+			case "warm_interval":
+				if !d.NextArg() {
+					return d.ArgErr()
 				}
 				var err error
-				hp.ForwardCookies, err = h.BoolVal()
+				h.WarmInterval, err = parseInt(d.Val())
 				if err != nil {
-					return nil, h.Errf("invalid forward_cookies value: %v", err)
+					return fmt.Errorf("invalid warm_interval value: %v", err)
 				}
 
-			case "forward_headers":
-				var headers []string
-				for h.NextArg() {
-					headers = append(headers, h.Val())
+			case "warm_refresh":
+				if !d.NextArg() {
+					return d.ArgErr()
 				}
-				hp.ForwardHeaders = headers
+				h.WarmRefresh = d.Val()
 
-			case "cache_ttl":
-				if !h.NextArg() {
-					return nil, h.ArgErr()
+			case "warm_concurrency":
+				if !d.NextArg() {
+					return d.ArgErr()
 				}
 				var err error
-				hp.CacheTTL, err = h.IntVal()
+				h.WarmConcurrency, err = parseInt(d.Val())
 				if err != nil {
-					return nil, h.Errf("invalid cache_ttl value: %v", err)
+					return fmt.Errorf("invalid warm_concurrency value: %v", err)
+				}
+
+			case "warm_pool_share":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				var err error
+				h.WarmPoolShare, err = strconv.ParseFloat(d.Val(), 64)
+				if err != nil {
+					return fmt.Errorf("invalid warm_pool_share value: %v", err)
 				}
 
 			case "max_browsers":
-				if !h.NextArg() {
-					return nil, h.ArgErr()
+				if !d.NextArg() {
+					return d.ArgErr()
 				}
 				var err error
-				hp.MaxBrowsers, err = h.IntVal()
+				h.MaxBrowsers, err = parseInt(d.Val())
 				if err != nil {
-					return nil, h.Errf("invalid max_browsers value: %v", err)
+					return fmt.Errorf("invalid max_browsers value: %v", err)
 				}
 
 			case "optimize_resources":
-				if !h.NextArg() {
-					return nil, h.ArgErr()
+				if !d.NextArg() {
+					return d.ArgErr()
 				}
 				var err error
-				hp.OptimizeResources, err = h.BoolVal()
+				h.OptimizeResources, err = parseBool(d.Val())
 				if err != nil {
-					return nil, h.Errf("invalid optimize_resources value: %v", err)
+					return fmt.Errorf("invalid optimize_resources value: %v", err)
 				}
 
 			case "compress_images":
-				if !h.NextArg() {
-					return nil, h.ArgErr()
+				if !d.NextArg() {
+					return d.ArgErr()
 				}
 				var err error
-				hp.CompressImages, err = h.BoolVal()
+				h.CompressImages, err = parseBool(d.Val())
 				if err != nil {
-					return nil, h.Errf("invalid compress_images value: %v", err)
+					return fmt.Errorf("invalid compress_images value: %v", err)
 				}
 
 			case "minify_content":
-				if !h.NextArg() {
-					return nil, h.ArgErr()
+				if !d.NextArg() {
+					return d.ArgErr()
 				}
 				var err error
-				hp.MinifyContent, err = h.BoolVal()
+				h.MinifyContent, err = parseBool(d.Val())
 				if err != nil {
-					return nil, h.Errf("invalid minify_content value: %v", err)
+					return fmt.Errorf("invalid minify_content value: %v", err)
 				}
 
-			default:
-				return nil, h.Errf("unknown subdirective: %s", h.Val())
-			}
-		}
-	}
-
-	return &hp, nil
-}
+			case "output":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.Output = d.Val()
 
-// UnmarshalCaddyfile implements caddyfile.Unmarshaler.
-func (h *HeadlessProxy) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
-	for d.Next() {
-		if !d.NextArg() {
-			return d.ArgErr()
-		}
-		h.Upstream = d.Val()
+			case "formats":
+				var formats []string
+				for d.NextArg() {
+					formats = append(formats, d.Val())
+				}
+				if len(formats) == 0 {
+					return d.ArgErr()
+				}
+				h.Formats = formats
 
-		if d.NextArg() {
-			return d.ArgErr()
-		}
+			case "diff_sample_rate":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				var err error
+				h.DiffSampleRate, err = strconv.ParseFloat(d.Val(), 64)
+				if err != nil {
+					return fmt.Errorf("invalid diff_sample_rate value: %v", err)
+				}
 
-		for d.NextBlock(0) {
-			switch d.Val() {
-			case "timeout":
+			case "diff_prefix_depth":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
 				var err error
-				h.Timeout, err = parseInt(d.Val())
+				h.DiffPrefixDepth, err = parseInt(d.Val())
 				if err != nil {
-					return fmt.Errorf("invalid timeout value: %v", err)
+					return fmt.Errorf("invalid diff_prefix_depth value: %v", err)
 				}
 
-			case "user_agent":
+			case "profile":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
-				h.UserAgent = d.Val()
+				name := d.Val()
+				var profile EmulationProfile
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					switch d.Val() {
+					case "viewport":
+						args := d.RemainingArgs()
+						if len(args) != 2 {
+							return d.ArgErr()
+						}
+						var err error
+						if profile.Width, err = parseInt(args[0]); err != nil {
+							return fmt.Errorf("invalid viewport width: %v", err)
+						}
+						if profile.Height, err = parseInt(args[1]); err != nil {
+							return fmt.Errorf("invalid viewport height: %v", err)
+						}
+					case "scale":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						var err error
+						profile.DeviceScaleFactor, err = strconv.ParseFloat(d.Val(), 64)
+						if err != nil {
+							return fmt.Errorf("invalid scale value: %v", err)
+						}
+					case "mobile":
+						profile.Mobile = true
+					case "touch":
+						profile.Touch = true
+					case "user_agent":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						profile.UserAgent = d.Val()
+					default:
+						return fmt.Errorf("unknown profile subdirective: %s", d.Val())
+					}
+				}
+				if h.Profiles == nil {
+					h.Profiles = make(map[string]EmulationProfile)
+				}
+				h.Profiles[name] = profile
 
-			case "enable_js":
+			case "throttle_profile":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				name := d.Val()
+				var throttle ThrottleProfile
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					subdirective := d.Val()
+					if subdirective == "offline" {
+						throttle.Offline = true
+						continue
+					}
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					value, err := strconv.ParseFloat(d.Val(), 64)
+					if err != nil {
+						return fmt.Errorf("invalid %s value: %v", subdirective, err)
+					}
+					switch subdirective {
+					case "latency":
+						throttle.Latency = value
+					case "download":
+						throttle.Download = value
+					case "upload":
+						throttle.Upload = value
+					case "cpu":
+						throttle.CPU = value
+					default:
+						return fmt.Errorf("unknown throttle_profile subdirective: %s", subdirective)
+					}
+				}
+				if h.Throttles == nil {
+					h.Throttles = make(map[string]ThrottleProfile)
+				}
+				h.Throttles[name] = throttle
+
+			case "throttle":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.Throttle = d.Val()
+
+			case "throttle_header":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.ThrottleHeader = d.Val()
+
+			case "block_resource_types":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				h.BlockResourceTypes = append(h.BlockResourceTypes, args...)
+
+			case "block_domains":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				h.BlockDomains = append(h.BlockDomains, args...)
+
+			case "allow_domains":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				h.AllowDomains = append(h.AllowDomains, args...)
+
+			case "filter_lists":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				h.FilterLists = append(h.FilterLists, args...)
+
+			case "filter_list_reload":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
 				var err error
-				h.EnableJS, err = parseBool(d.Val())
+				h.FilterListReload, err = parseInt(d.Val())
 				if err != nil {
-					return fmt.Errorf("invalid enable_js value: %v", err)
+					return fmt.Errorf("invalid filter_list_reload value: %v", err)
 				}
 
-			case "forward_cookies":
+			case "mock":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				mock := MockRule{Pattern: d.Val()}
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					switch d.Val() {
+					case "file":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						mock.File = d.Val()
+					case "body":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						mock.Body = d.Val()
+					case "status":
+						if !d.NextArg() {
+							return d.ArgErr()
+						}
+						var err error
+						mock.Status, err = parseInt(d.Val())
+						if err != nil {
+							return fmt.Errorf("invalid mock status: %v", err)
+						}
+					case "header":
+						args := d.RemainingArgs()
+						if len(args) != 2 {
+							return d.ArgErr()
+						}
+						if mock.Headers == nil {
+							mock.Headers = make(map[string]string)
+						}
+						mock.Headers[args[0]] = args[1]
+					default:
+						return fmt.Errorf("unknown mock subdirective: %s", d.Val())
+					}
+				}
+				h.Mocks = append(h.Mocks, mock)
+
+			case "browser_proxy":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				h.BrowserProxies = append(h.BrowserProxies, args...)
+
+			case "browser_proxy_scope":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.BrowserProxyScope = d.Val()
+
+			case "browser_proxy_selection":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.BrowserProxySelection = d.Val()
+
+			case "browser_proxy_check_url":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.BrowserProxyCheckURL = d.Val()
+
+			case "browser_proxy_check_interval":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
 				var err error
-				h.ForwardCookies, err = parseBool(d.Val())
+				h.BrowserProxyCheckInterval, err = parseInt(d.Val())
 				if err != nil {
-					return fmt.Errorf("invalid forward_cookies value: %v", err)
+					return fmt.Errorf("invalid browser_proxy_check_interval value: %v", err)
 				}
 
-			case "forward_headers":
-				var headers []string
-				for d.NextArg() {
-					headers = append(headers, d.Val())
+			case "override_secret":
+				if !d.NextArg() {
+					return d.ArgErr()
 				}
-				h.ForwardHeaders = headers
+				h.OverrideSecret = d.Val()
 
-			case "cache_ttl":
+			case "default_profile":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.DefaultProfile = d.Val()
+
+			case "profile_header":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.ProfileHeader = d.Val()
+
+			case "profile_match":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
 				var err error
-				h.CacheTTL, err = parseInt(d.Val())
+				h.ProfileMatch, err = parseBool(d.Val())
 				if err != nil {
-					return fmt.Errorf("invalid cache_ttl value: %v", err)
+					return fmt.Errorf("invalid profile_match value: %v", err)
 				}
 
-			case "max_browsers":
+			case "locale":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
-				var err error
-				h.MaxBrowsers, err = parseInt(d.Val())
+				h.Locale = d.Val()
+
+			case "timezone":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.Timezone = d.Val()
+
+			case "timezone_header":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.TimezoneHeader = d.Val()
+
+			case "geolocation":
+				args := d.RemainingArgs()
+				if len(args) < 2 || len(args) > 3 {
+					return d.ArgErr()
+				}
+				geo, err := parseGeolocation(strings.Join(args, ","))
 				if err != nil {
-					return fmt.Errorf("invalid max_browsers value: %v", err)
+					return err
 				}
+				h.Geolocation = geo
 
-			case "optimize_resources":
+			case "geolocation_header":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.GeolocationHeader = d.Val()
+
+			case "color_scheme":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.ColorScheme = d.Val()
+
+			case "reduced_motion":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.ReducedMotion = d.Val()
+
+			case "forced_colors":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.ForcedColors = d.Val()
+
+			case "media":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.Media = d.Val()
+
+			case "media_client_hints":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
 				var err error
-				h.OptimizeResources, err = parseBool(d.Val())
+				h.MediaClientHints, err = parseBool(d.Val())
 				if err != nil {
-					return fmt.Errorf("invalid optimize_resources value: %v", err)
+					return fmt.Errorf("invalid media_client_hints value: %v", err)
 				}
 
-			case "compress_images":
+			case "har_dir":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.HARDir = d.Val()
+
+			case "har_header":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				h.HARHeader = d.Val()
+
+			case "har_max_body_size":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
 				var err error
-				h.CompressImages, err = parseBool(d.Val())
+				h.HARMaxBodySize, err = parseInt(d.Val())
 				if err != nil {
-					return fmt.Errorf("invalid compress_images value: %v", err)
+					return fmt.Errorf("invalid har_max_body_size value: %v", err)
 				}
 
-			case "minify_content":
+			case "fail_on_js_error":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
 				var err error
-				h.MinifyContent, err = parseBool(d.Val())
+				h.FailOnJSError, err = parseBool(d.Val())
 				if err != nil {
-					return fmt.Errorf("invalid minify_content value: %v", err)
+					return fmt.Errorf("invalid fail_on_js_error value: %v", err)
 				}
 
 			default:
@@ -19,6 +19,9 @@ type HealthStatus struct {
 	BrowserPool    BrowserPoolStatus `json:"browser_pool"`
 	CacheStatus    CacheStatus       `json:"cache"`
 	SystemResources SystemResources   `json:"system_resources"`
+	RenderDiff     map[string]DiffPathStats `json:"render_diff,omitempty"`
+	BrowserProxies map[string]bool `json:"browser_proxies,omitempty"`
+	CacheWarmer    *WarmerStatus   `json:"cache_warmer,omitempty"`
 	Version        string            `json:"version"`
 	Timestamp      string            `json:"timestamp"`
 }
@@ -35,6 +38,7 @@ type BrowserPoolStatus struct {
 type CacheStatus struct {
 	Enabled    bool  `json:"enabled"`
 	Size       int   `json:"size"`
+	Bytes      int64 `json:"bytes"`
 	HitRate    float64 `json:"hit_rate"`
 	TTL        int   `json:"ttl"`
 }
@@ -79,9 +83,7 @@ func (h *HeadlessProxy) getHealthStatus() HealthStatus {
 	}
 
 	// Get cache size
-	h.cacheLock.RLock()
-	cacheSize := len(h.cache)
-	h.cacheLock.RUnlock()
+	cacheStats := h.cacheStats()
 
 	// Determine overall status
 	status := "healthy"
@@ -91,6 +93,19 @@ func (h *HeadlessProxy) getHealthStatus() HealthStatus {
 		status = "degraded"
 	}
 
+	// Report egress proxy health
+	var proxyStatus map[string]bool
+	if h.proxyPool != nil {
+		proxyStatus = h.proxyPool.Status()
+	}
+
+	// Report cache warming progress
+	var warmerStatus *WarmerStatus
+	if h.warmer != nil {
+		warmer := h.warmer.Status()
+		warmerStatus = &warmer
+	}
+
 	var memStats runtime.MemStats
 	runtime.ReadMemStats(&memStats)
 
@@ -105,7 +120,8 @@ func (h *HeadlessProxy) getHealthStatus() HealthStatus {
 		},
 		CacheStatus: CacheStatus{
 			Enabled: h.CacheTTL > 0,
-			Size:    cacheSize,
+			Size:    cacheStats.Entries,
+			Bytes:   cacheStats.Bytes,
 			HitRate: hitRate,
 			TTL:     h.CacheTTL,
 		},
@@ -114,6 +130,9 @@ func (h *HeadlessProxy) getHealthStatus() HealthStatus {
 			MemoryUsage: float64(memStats.Alloc) / 1024 / 1024,
 			GoRoutines:  runtime.NumGoroutine(),
 		},
+		RenderDiff: h.diffSampler.Stats(),
+		BrowserProxies: proxyStatus,
+		CacheWarmer:    warmerStatus,
 		Version:   "1.0.0",
 		Timestamp: time.Now().Format(time.RFC3339),
 	}
@@ -137,6 +156,9 @@ func (h *HeadlessProxy) checkBrowsersHealth() (int, int) {
 			h.logger.Warn("replacing unhealthy browser in pool", zap.Int("index", i))
 			_ = browser.Close()
 			h.metrics.browserClosedTotal.Inc()
+			if h.proxyPool != nil {
+				h.proxyPool.Release(browser)
+			}
 			
 			newBrowser := h.createBrowser()
 			if newBrowser != nil {
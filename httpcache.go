@@ -0,0 +1,192 @@
+package headlessproxy
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheRule forces or forbids caching for the paths matching a pattern
+type CacheRule struct {
+	// Path pattern where * matches any characters, e.g. "/static/*"
+	Path string `json:"path"`
+
+	// force caches responses regardless of upstream headers; forbid never caches them
+	Action string `json:"action"`
+
+	// Seconds forced responses are cached for (defaults to cache_ttl)
+	TTL int `json:"ttl,omitempty"`
+}
+
+// matchCacheRule returns the first cache rule matching the path of a request
+func (h *HeadlessProxy) matchCacheRule(r *http.Request) *CacheRule {
+	for i := range h.CacheRules {
+		if wildcardMatch(h.CacheRules[i].Path, r.URL.Path) {
+			return &h.CacheRules[i]
+		}
+	}
+	return nil
+}
+
+// heuristicStatuses are the statuses a response may be cached with without
+// explicit freshness information (RFC 9110, section 15.1)
+var heuristicStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+}
+
+// cacheLifetime decides, following RFC 9111 for a shared cache, whether a
+// rendered response may be stored and for how long it stays fresh. It also
+// returns the age the upstream response already had. Responses stored with no
+// freshness left, such as those marked no-cache, are revalidated upstream
+// before every use, so they are only stored if upstream sent validators.
+func (h *HeadlessProxy) cacheLifetime(r *http.Request, result *renderResult, now time.Time) (time.Duration, time.Duration, bool) {
+	// Errors are never stored; they count as failed renders
+	if result.StatusCode < 200 || result.StatusCode >= 500 {
+		return 0, 0, false
+	}
+	heuristic := heuristicStatuses[result.StatusCode]
+
+	defaultTTL := time.Duration(h.CacheTTL) * time.Second
+	if rule := h.matchCacheRule(r); rule != nil && rule.Action == "force" {
+		if !heuristic {
+			return 0, 0, false
+		}
+		if rule.TTL > 0 {
+			return time.Duration(rule.TTL) * time.Second, 0, true
+		}
+		return defaultTTL, 0, true
+	}
+
+	upstream := result.Upstream
+	directives := parseCacheControl(strings.Join(upstream.Values("Cache-Control"), ","))
+	if _, ok := directives["no-store"]; ok {
+		return 0, 0, false
+	}
+	if _, ok := directives["private"]; ok {
+		return 0, 0, false
+	}
+	for _, name := range strings.Split(strings.Join(upstream.Values("Vary"), ","), ",") {
+		if strings.TrimSpace(name) == "*" {
+			return 0, 0, false
+		}
+	}
+
+	// Responses to authorized requests are only shared when upstream allows it
+	if r.Header.Get("Authorization") != "" {
+		_, public := directives["public"]
+		_, sMaxAge := directives["s-maxage"]
+		_, mustRevalidate := directives["must-revalidate"]
+		if !public && !sMaxAge && !mustRevalidate {
+			return 0, 0, false
+		}
+	}
+
+	// The age of the response when it left upstream, or since its Date
+	age := time.Duration(0)
+	if seconds, err := strconv.ParseInt(upstream.Get("Age"), 10, 64); err == nil && seconds > 0 {
+		age = time.Duration(seconds) * time.Second
+	}
+	date, err := http.ParseTime(upstream.Get("Date"))
+	if err != nil {
+		date = now
+	}
+	if apparent := now.Sub(date); apparent > age {
+		age = apparent
+	}
+	revalidatable := upstream.Get("ETag") != "" || upstream.Get("Last-Modified") != ""
+
+	// no-cache responses may be stored, but must be revalidated before use
+	if _, ok := directives["no-cache"]; ok {
+		return 0, age, revalidatable
+	}
+
+	// s-maxage wins over max-age, which wins over Expires
+	lifetime, explicit := directives.seconds("s-maxage")
+	if !explicit {
+		lifetime, explicit = directives.seconds("max-age")
+	}
+	if !explicit && upstream.Get("Expires") != "" {
+		explicit = true
+		if expires, err := http.ParseTime(upstream.Get("Expires")); err == nil {
+			lifetime = expires.Sub(date)
+		}
+	}
+	if !explicit {
+		return defaultTTL, 0, heuristic && defaultTTL > 0
+	}
+
+	ttl := lifetime - age
+	if h.CacheTTLCap && ttl > defaultTTL {
+		ttl = defaultTTL
+	}
+	return ttl, age, ttl > 0 || revalidatable
+}
+
+// varyHeaders returns the request headers named by a Vary header, canonicalized and sorted
+func varyHeaders(vary string) []string {
+	var names []string
+	for _, name := range strings.Split(vary, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// variantKey derives the cache key of the variant of a response matching the
+// values the render sent upstream for the vary headers
+func (h *HeadlessProxy) variantKey(key string, r *http.Request, vary []string) string {
+	hasher := md5.New()
+	hasher.Write([]byte(key))
+	for _, name := range vary {
+		if value, ok := h.upstreamHeader(r, name); ok {
+			hasher.Write([]byte("|" + name + ":" + value))
+		}
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// upstreamHeader returns the value of a canonical header name a render sends
+// upstream for a request. ok is false for headers not passed on from the
+// client, which the browser sends the same way for every request.
+func (h *HeadlessProxy) upstreamHeader(r *http.Request, name string) (value string, ok bool) {
+	opts := h.getRenderOptions(r)
+	switch {
+	case name == "User-Agent":
+		return opts.UserAgent, true
+	case name == "Accept-Language":
+		return opts.AcceptLanguage, true
+	case strings.HasPrefix(name, "Sec-Ch-Ua"):
+		// Client Hints are the client's own or generated from the User-Agent
+		if h.ForwardUserAgent {
+			return strings.Join(r.Header.Values(name), ","), true
+		}
+		return opts.UserAgent, true
+	case name == "Cookie":
+		var cookies []string
+		for _, cookie := range h.forwardedCookies(r) {
+			cookies = append(cookies, cookie.Name+"="+cookie.Value)
+		}
+		return strings.Join(cookies, "; "), h.ForwardCookies
+	}
+	for _, header := range h.ForwardHeaders {
+		if http.CanonicalHeaderKey(header) == name {
+			return strings.Join(r.Header.Values(name), ","), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,117 @@
+package headlessproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheLifetime(t *testing.T) {
+	hp := &HeadlessProxy{
+		CacheTTL: 600,
+		CacheRules: []CacheRule{
+			{Path: "/static/*", Action: "force", TTL: 3600},
+			{Path: "/account/*", Action: "forbid"},
+		},
+	}
+	now := time.Now()
+	lifetimeFor := func(status int, path string, upstream http.Header, reqHeaders ...string) (time.Duration, time.Duration, bool) {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i+1 < len(reqHeaders); i += 2 {
+			r.Header.Set(reqHeaders[i], reqHeaders[i+1])
+		}
+		return hp.cacheLifetime(r, &renderResult{StatusCode: status, Upstream: upstream}, now)
+	}
+	lifetime := func(path string, upstream http.Header, reqHeaders ...string) (time.Duration, time.Duration, bool) {
+		return lifetimeFor(http.StatusOK, path, upstream, reqHeaders...)
+	}
+
+	// Without freshness information cache_ttl applies
+	ttl, _, ok := lifetime("/", nil)
+	assert.True(t, ok)
+	assert.Equal(t, 10*time.Minute, ttl)
+
+	// s-maxage wins over max-age, minus the age the response already has
+	ttl, age, ok := lifetime("/", http.Header{"Cache-Control": {"max-age=60, s-maxage=7200"}, "Age": {"200"}})
+	assert.True(t, ok)
+	assert.Equal(t, 7000*time.Second, ttl)
+	assert.Equal(t, 200*time.Second, age)
+
+	// cache_ttl caps the lifetime if configured
+	hp.CacheTTLCap = true
+	ttl, _, _ = lifetime("/", http.Header{"Cache-Control": {"s-maxage=7200"}})
+	assert.Equal(t, 10*time.Minute, ttl)
+	hp.CacheTTLCap = false
+
+	// Expires counts from Date
+	ttl, _, ok = lifetime("/", http.Header{
+		"Date":    {now.UTC().Format(http.TimeFormat)},
+		"Expires": {now.Add(time.Hour).UTC().Format(http.TimeFormat)},
+	})
+	assert.True(t, ok)
+	assert.InDelta(t, time.Hour.Seconds(), ttl.Seconds(), 1)
+	_, _, ok = lifetime("/", http.Header{"Expires": {"0"}})
+	assert.False(t, ok)
+
+	// Responses a shared cache must not store
+	for _, cc := range []string{"no-store", "private", "no-cache", "max-age=0"} {
+		_, _, ok = lifetime("/", http.Header{"Cache-Control": {cc}})
+		assert.False(t, ok, cc)
+	}
+	_, _, ok = lifetime("/", http.Header{"Vary": {"Accept-Encoding, *"}})
+	assert.False(t, ok)
+
+	// Responses without freshness left are stored for revalidation if upstream sent validators
+	for _, cc := range []string{"no-cache", "max-age=0"} {
+		ttl, _, ok = lifetime("/", http.Header{"Cache-Control": {cc}, "Etag": {`"v1"`}})
+		assert.True(t, ok, cc)
+		assert.Equal(t, time.Duration(0), ttl, cc)
+	}
+	_, _, ok = lifetime("/", http.Header{"Cache-Control": {"no-store"}, "Etag": {`"v1"`}})
+	assert.False(t, ok)
+
+	// Only some statuses are cached without freshness information, and errors never are
+	_, _, ok = lifetimeFor(http.StatusNotFound, "/", nil)
+	assert.True(t, ok)
+	_, _, ok = lifetimeFor(http.StatusFound, "/", nil)
+	assert.False(t, ok)
+	_, _, ok = lifetimeFor(http.StatusFound, "/", http.Header{"Cache-Control": {"max-age=60"}})
+	assert.True(t, ok)
+	_, _, ok = lifetimeFor(http.StatusServiceUnavailable, "/", http.Header{"Cache-Control": {"max-age=60"}})
+	assert.False(t, ok)
+	_, _, ok = lifetimeFor(http.StatusInternalServerError, "/static/app.js", nil)
+	assert.False(t, ok)
+	_, _, ok = lifetime("/", nil, "Authorization", "Bearer x")
+	assert.False(t, ok)
+	_, _, ok = lifetime("/", http.Header{"Cache-Control": {"public, max-age=60"}}, "Authorization", "Bearer x")
+	assert.True(t, ok)
+
+	// Forced routes ignore upstream headers
+	ttl, _, ok = lifetime("/static/app.js", http.Header{"Cache-Control": {"no-store"}})
+	assert.True(t, ok)
+	assert.Equal(t, time.Hour, ttl)
+	assert.Equal(t, []string{"Accept-Encoding", "Cookie"}, varyHeaders("cookie, accept-encoding"))
+}
+
+func TestVariantKey(t *testing.T) {
+	h := &HeadlessProxy{ForwardHeaders: []string{"x-tenant"}}
+	request := func(userAgent string, headers ...string) *http.Request {
+		r := httptest.NewRequest("GET", "/", nil)
+		for i := 0; i+1 < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+		return withRenderOptions(r, &renderOptions{UserAgent: userAgent, AcceptLanguage: "de-DE,de;q=0.9"})
+	}
+	vary := varyHeaders("User-Agent, Accept-Encoding, Accept-Language, X-Tenant")
+	key := h.variantKey("page", request("Chrome/120"), vary)
+
+	// Variants follow what the browser sent, not the client's own headers
+	assert.Equal(t, key, h.variantKey("page", request("Chrome/120", "User-Agent", "curl/8.4.0", "Accept-Encoding", "br", "Accept-Language", "fr"), vary))
+	assert.NotEqual(t, key, h.variantKey("page", request("Safari/605.1.15"), vary))
+
+	// Forwarded headers reach upstream as the client sent them
+	assert.NotEqual(t, key, h.variantKey("page", request("Chrome/120", "X-Tenant", "a"), vary))
+}
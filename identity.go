@@ -0,0 +1,181 @@
+package headlessproxy
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+	"go.uber.org/zap"
+)
+
+var (
+	chromeVersionPattern  = regexp.MustCompile(`(?:Headless)?Chrome/(\d+)\.([\d.]+)`)
+	edgeVersionPattern    = regexp.MustCompile(`Edg/(\d+)\.([\d.]+)`)
+	androidPattern        = regexp.MustCompile(`Android (\d+(?:\.\d+)*)(?:; ([^;)]+))?`)
+	windowsPattern        = regexp.MustCompile(`Windows NT (\d+\.\d+)`)
+	macPattern            = regexp.MustCompile(`Mac OS X (\d+[_.]\d+(?:[_.]\d+)?)`)
+	clientHintListPattern = regexp.MustCompile(`"([^"]*)"\s*;\s*v\s*=\s*"([^"]*)"`)
+)
+
+// detectBrowserIdentity records the User-Agent of a launched browser, so the
+// default identity matches the engine actually rendering the page
+func (h *HeadlessProxy) detectBrowserIdentity(browser *rod.Browser) {
+	h.identityLock.RLock()
+	known := h.browserUserAgent != ""
+	h.identityLock.RUnlock()
+	if known {
+		return
+	}
+
+	version, err := proto.BrowserGetVersion{}.Call(browser)
+	if err != nil {
+		h.logger.Warn("failed to get browser version", zap.Error(err))
+		return
+	}
+
+	userAgent := headfulUserAgent(version.UserAgent)
+
+	h.identityLock.Lock()
+	h.browserUserAgent = userAgent
+	h.identityLock.Unlock()
+
+	h.logger.Info("detected browser identity",
+		zap.String("product", version.Product),
+		zap.String("user_agent", userAgent),
+	)
+}
+
+// headfulUserAgent presents a headless browser's User-Agent as a regular one.
+// Headless Chrome announces itself as HeadlessChrome, which sites block.
+func headfulUserAgent(ua string) string {
+	return strings.Replace(ua, "HeadlessChrome/", "Chrome/", 1)
+}
+
+// fallbackUserAgent is presented when the launched browser's User-Agent could
+// not be detected, matching the Chrome version of the device profiles
+const fallbackUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+
+// defaultUserAgent returns the configured User-Agent or the launched browser's own
+func (h *HeadlessProxy) defaultUserAgent() string {
+	if h.UserAgent != "" {
+		return h.UserAgent
+	}
+
+	h.identityLock.RLock()
+	defer h.identityLock.RUnlock()
+	if h.browserUserAgent == "" {
+		return fallbackUserAgent
+	}
+	return h.browserUserAgent
+}
+
+// resolveIdentity sets the User-Agent and matching Client Hints metadata of a render
+func (h *HeadlessProxy) resolveIdentity(r *http.Request, opts *renderOptions) {
+	if h.ForwardUserAgent {
+		if ua := r.Header.Get("User-Agent"); ua != "" {
+			opts.UserAgent = ua
+			opts.UserAgentMetadata = clientHintsMetadata(r)
+			if opts.UserAgentMetadata == nil {
+				opts.UserAgentMetadata = userAgentMetadata(ua)
+			}
+			return
+		}
+	}
+
+	opts.UserAgent = h.renderUserAgent(opts)
+	opts.UserAgentMetadata = userAgentMetadata(opts.UserAgent)
+}
+
+// userAgentMetadata generates Client Hints metadata consistent with a User-Agent.
+// Browsers other than Chromium don't send Client Hints, so nil is returned for them.
+func userAgentMetadata(ua string) *proto.EmulationUserAgentMetadata {
+	chrome := chromeVersionPattern.FindStringSubmatch(ua)
+	if chrome == nil {
+		return nil
+	}
+
+	major, full := chrome[1], chrome[1]+"."+chrome[2]
+	brand, brandMajor, brandFull := "Google Chrome", major, full
+	if edge := edgeVersionPattern.FindStringSubmatch(ua); edge != nil {
+		brand, brandMajor, brandFull = "Microsoft Edge", edge[1], edge[1]+"."+edge[2]
+	}
+
+	metadata := &proto.EmulationUserAgentMetadata{
+		Brands: []*proto.EmulationUserAgentBrandVersion{
+			{Brand: "Not_A Brand", Version: "8"},
+			{Brand: "Chromium", Version: major},
+			{Brand: brand, Version: brandMajor},
+		},
+		FullVersionList: []*proto.EmulationUserAgentBrandVersion{
+			{Brand: "Not_A Brand", Version: "8.0.0.0"},
+			{Brand: "Chromium", Version: full},
+			{Brand: brand, Version: brandFull},
+		},
+		Mobile:       strings.Contains(ua, "Mobile"),
+		Architecture: "x86",
+		Bitness:      "64",
+	}
+
+	switch {
+	case strings.Contains(ua, "Android"):
+		metadata.Platform = "Android"
+		metadata.Architecture = ""
+		metadata.Bitness = ""
+		if android := androidPattern.FindStringSubmatch(ua); android != nil {
+			metadata.PlatformVersion = android[1]
+			metadata.Model = strings.TrimSpace(android[2])
+		}
+	case strings.Contains(ua, "Windows"):
+		metadata.Platform = "Windows"
+		// Windows 11 still reports NT 10.0; the platform version can't tell them apart
+		if windows := windowsPattern.FindStringSubmatch(ua); windows != nil {
+			metadata.PlatformVersion = windows[1] + ".0"
+		}
+	case strings.Contains(ua, "Macintosh"):
+		metadata.Platform = "macOS"
+		if mac := macPattern.FindStringSubmatch(ua); mac != nil {
+			metadata.PlatformVersion = strings.ReplaceAll(mac[1], "_", ".")
+		}
+	case strings.Contains(ua, "CrOS"):
+		metadata.Platform = "Chrome OS"
+	default:
+		metadata.Platform = "Linux"
+	}
+
+	return metadata
+}
+
+// clientHintsMetadata builds metadata from the client's own Sec-CH-UA headers
+func clientHintsMetadata(r *http.Request) *proto.EmulationUserAgentMetadata {
+	brands := parseClientHintList(r.Header.Get("Sec-CH-UA"))
+	if len(brands) == 0 {
+		return nil
+	}
+
+	return &proto.EmulationUserAgentMetadata{
+		Brands:          brands,
+		FullVersionList: parseClientHintList(r.Header.Get("Sec-CH-UA-Full-Version-List")),
+		Platform:        unquoteClientHint(r.Header.Get("Sec-CH-UA-Platform")),
+		PlatformVersion: unquoteClientHint(r.Header.Get("Sec-CH-UA-Platform-Version")),
+		Architecture:    unquoteClientHint(r.Header.Get("Sec-CH-UA-Arch")),
+		Model:           unquoteClientHint(r.Header.Get("Sec-CH-UA-Model")),
+		Bitness:         unquoteClientHint(r.Header.Get("Sec-CH-UA-Bitness")),
+		Mobile:          r.Header.Get("Sec-CH-UA-Mobile") == "?1",
+	}
+}
+
+// parseClientHintList parses a brand list such as `"Chromium";v="120", "Not_A Brand";v="8"`
+func parseClientHintList(value string) []*proto.EmulationUserAgentBrandVersion {
+	var brands []*proto.EmulationUserAgentBrandVersion
+	for _, match := range clientHintListPattern.FindAllStringSubmatch(value, -1) {
+		brands = append(brands, &proto.EmulationUserAgentBrandVersion{Brand: match[1], Version: match[2]})
+	}
+	return brands
+}
+
+// unquoteClientHint strips the quotes of a structured header string
+func unquoteClientHint(value string) string {
+	return strings.Trim(strings.TrimSpace(value), `"`)
+}
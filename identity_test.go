@@ -0,0 +1,194 @@
+package headlessproxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-rod/rod/lib/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeadfulUserAgent(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		headful   string
+	}{
+		{
+			"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/121.0.6167.85 Safari/537.36",
+			"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.6167.85 Safari/537.36",
+		},
+		// Regular User-Agents are left alone
+		{fallbackUserAgent, fallbackUserAgent},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.headful, headfulUserAgent(tt.userAgent), tt.userAgent)
+	}
+}
+
+func TestDefaultUserAgent(t *testing.T) {
+	// Until the launched browser is known, the fallback is presented
+	h := &HeadlessProxy{}
+	assert.Equal(t, fallbackUserAgent, h.defaultUserAgent())
+
+	h.browserUserAgent = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.6167.85 Safari/537.36"
+	assert.Equal(t, h.browserUserAgent, h.defaultUserAgent())
+
+	h.UserAgent = "Custom/1.0"
+	assert.Equal(t, "Custom/1.0", h.defaultUserAgent())
+
+	// The fallback carries Client Hints like a detected Chrome would
+	metadata := userAgentMetadata(fallbackUserAgent)
+	if assert.NotNil(t, metadata) {
+		assert.Equal(t, "Windows", metadata.Platform)
+		assert.Equal(t, "120", metadata.Brands[1].Version)
+	}
+}
+
+func TestUserAgentMetadata(t *testing.T) {
+	tests := []struct {
+		name            string
+		userAgent       string
+		brand           string
+		major           string
+		full            string
+		platform        string
+		platformVersion string
+		model           string
+		mobile          bool
+	}{
+		{
+			name:            "windows chrome",
+			userAgent:       "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.109 Safari/537.36",
+			brand:           "Google Chrome",
+			major:           "120",
+			full:            "120.0.6099.109",
+			platform:        "Windows",
+			platformVersion: "10.0.0",
+		},
+		{
+			name:            "edge",
+			userAgent:       "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91",
+			brand:           "Microsoft Edge",
+			major:           "120",
+			full:            "120.0.2210.91",
+			platform:        "Windows",
+			platformVersion: "10.0.0",
+		},
+		{
+			name:            "android",
+			userAgent:       "Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+			brand:           "Google Chrome",
+			major:           "120",
+			full:            "120.0.0.0",
+			platform:        "Android",
+			platformVersion: "13",
+			model:           "Pixel 7",
+			mobile:          true,
+		},
+		{
+			name:            "mac",
+			userAgent:       "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36",
+			brand:           "Google Chrome",
+			major:           "119",
+			full:            "119.0.0.0",
+			platform:        "macOS",
+			platformVersion: "10.15.7",
+		},
+		{
+			name:      "headless chrome",
+			userAgent: "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/121.0.6167.85 Safari/537.36",
+			brand:     "Google Chrome",
+			major:     "121",
+			full:      "121.0.6167.85",
+			platform:  "Linux",
+		},
+	}
+	for _, tt := range tests {
+		metadata := userAgentMetadata(tt.userAgent)
+		if !assert.NotNil(t, metadata, tt.name) {
+			continue
+		}
+		assert.Equal(t, []*proto.EmulationUserAgentBrandVersion{
+			{Brand: "Not_A Brand", Version: "8"},
+			{Brand: "Chromium", Version: tt.major},
+			{Brand: tt.brand, Version: tt.major},
+		}, metadata.Brands, tt.name)
+		assert.Equal(t, tt.full, metadata.FullVersionList[2].Version, tt.name)
+		assert.Equal(t, tt.platform, metadata.Platform, tt.name)
+		assert.Equal(t, tt.platformVersion, metadata.PlatformVersion, tt.name)
+		assert.Equal(t, tt.model, metadata.Model, tt.name)
+		assert.Equal(t, tt.mobile, metadata.Mobile, tt.name)
+	}
+
+	// Browsers that send no Client Hints get none
+	for _, userAgent := range []string{
+		"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Safari/605.1.15",
+		"curl/8.4.0",
+		"",
+	} {
+		assert.Nil(t, userAgentMetadata(userAgent), userAgent)
+	}
+}
+
+func TestParseClientHintList(t *testing.T) {
+	tests := []struct {
+		value  string
+		brands []*proto.EmulationUserAgentBrandVersion
+	}{
+		{
+			`"Not_A Brand";v="8", "Chromium";v="120", "Google Chrome";v="120"`,
+			[]*proto.EmulationUserAgentBrandVersion{
+				{Brand: "Not_A Brand", Version: "8"},
+				{Brand: "Chromium", Version: "120"},
+				{Brand: "Google Chrome", Version: "120"},
+			},
+		},
+		{
+			`"Chromium" ; v = "120.0.6099.109"`,
+			[]*proto.EmulationUserAgentBrandVersion{{Brand: "Chromium", Version: "120.0.6099.109"}},
+		},
+		// Malformed entries are skipped
+		{
+			`Chromium;v="120", "Google Chrome";v=120, "Edge";v="120"`,
+			[]*proto.EmulationUserAgentBrandVersion{{Brand: "Edge", Version: "120"}},
+		},
+		{`"Chromium"`, nil},
+		{`"Chromium";v="120`, nil},
+		{"garbage", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.brands, parseClientHintList(tt.value), tt.value)
+	}
+}
+
+func TestClientHintsMetadata(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Sec-CH-UA", `"Chromium";v="120", "Google Chrome";v="120"`)
+	r.Header.Set("Sec-CH-UA-Full-Version-List", `"Chromium";v="120.0.6099.109"`)
+	r.Header.Set("Sec-CH-UA-Platform", `"Android"`)
+	r.Header.Set("Sec-CH-UA-Platform-Version", ` "13.0.0" `)
+	r.Header.Set("Sec-CH-UA-Model", `"Pixel 7"`)
+	r.Header.Set("Sec-CH-UA-Mobile", "?1")
+	assert.Equal(t, &proto.EmulationUserAgentMetadata{
+		Brands: []*proto.EmulationUserAgentBrandVersion{
+			{Brand: "Chromium", Version: "120"},
+			{Brand: "Google Chrome", Version: "120"},
+		},
+		FullVersionList: []*proto.EmulationUserAgentBrandVersion{{Brand: "Chromium", Version: "120.0.6099.109"}},
+		Platform:        "Android",
+		PlatformVersion: "13.0.0",
+		Model:           "Pixel 7",
+		Mobile:          true,
+	}, clientHintsMetadata(r))
+
+	// Without a usable brand list, the client's hints are not used
+	for _, value := range []string{"", "Chromium", `"Chromium";v=120`} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Sec-CH-UA", value)
+		r.Header.Set("Sec-CH-UA-Platform", `"Android"`)
+		assert.Nil(t, clientHintsMetadata(r), value)
+	}
+}
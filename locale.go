@@ -0,0 +1,181 @@
+package headlessproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+	"golang.org/x/text/language"
+)
+
+// Geolocation is a position reported to pages through the Geolocation API
+type Geolocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+
+	// Accuracy in meters (0 uses 100)
+	Accuracy float64 `json:"accuracy,omitempty"`
+}
+
+// String formats a geolocation the way it is parsed from headers
+func (g *Geolocation) String() string {
+	return strconv.FormatFloat(g.Latitude, 'f', -1, 64) + "," +
+		strconv.FormatFloat(g.Longitude, 'f', -1, 64) + "," +
+		strconv.FormatFloat(g.Accuracy, 'f', -1, 64)
+}
+
+// parseGeolocation parses "latitude,longitude[,accuracy]"
+func parseGeolocation(s string) (*Geolocation, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("invalid geolocation %q: expected latitude,longitude[,accuracy]", s)
+	}
+
+	values := make([]float64, len(parts))
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid geolocation %q: %v", s, err)
+		}
+		values[i] = v
+	}
+
+	geo := &Geolocation{Latitude: values[0], Longitude: values[1]}
+	if len(values) == 3 {
+		geo.Accuracy = values[2]
+	}
+	if geo.Latitude < -90 || geo.Latitude > 90 || geo.Longitude < -180 || geo.Longitude > 180 || geo.Accuracy < 0 {
+		return nil, fmt.Errorf("invalid geolocation %q: out of range", s)
+	}
+	return geo, nil
+}
+
+// preferredLanguage returns the language tag with the highest quality in an Accept-Language header
+func preferredLanguage(acceptLanguage string) string {
+	type tag struct {
+		name string
+		q    float64
+	}
+
+	var tags []tag
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.TrimSpace(name)
+		if name == "" || name == "*" {
+			continue
+		}
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			if parsed, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > 0 {
+			tags = append(tags, tag{name: name, q: q})
+		}
+	}
+	if len(tags) == 0 {
+		return ""
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	return tags[0].name
+}
+
+// acceptLanguageFor builds an Accept-Language header preferring a locale, e.g. "de-DE,de;q=0.9"
+func acceptLanguageFor(locale string) string {
+	lang, _, found := strings.Cut(locale, "-")
+	if !found {
+		return locale
+	}
+	return locale + "," + lang + ";q=0.9"
+}
+
+// resolveLocale fills in the locale, timezone and geolocation of the render options
+func (h *HeadlessProxy) resolveLocale(r *http.Request, opts *renderOptions) {
+	switch h.Locale {
+	case "":
+	case "auto":
+		// Mirror the client, keeping its full Accept-Language list
+		if acceptLanguage := r.Header.Get("Accept-Language"); acceptLanguage != "" {
+			// Only well-formed tags reach Chrome; others render without a locale override
+			if tag, err := language.Parse(preferredLanguage(acceptLanguage)); err == nil {
+				opts.Locale = tag.String()
+			}
+			opts.AcceptLanguage = acceptLanguage
+		}
+	default:
+		opts.Locale = h.Locale
+		opts.AcceptLanguage = acceptLanguageFor(h.Locale)
+	}
+
+	opts.Timezone = h.Timezone
+	if h.TimezoneHeader != "" {
+		if tz := strings.TrimSpace(r.Header.Get(h.TimezoneHeader)); tz != "" {
+			if _, err := time.LoadLocation(tz); err == nil {
+				opts.Timezone = tz
+			}
+		}
+	}
+
+	opts.Geolocation = h.Geolocation
+	if h.GeolocationHeader != "" {
+		if value := r.Header.Get(h.GeolocationHeader); value != "" {
+			if geo, err := parseGeolocation(value); err == nil {
+				opts.Geolocation = geo
+			}
+		}
+	}
+}
+
+// applyLocale emulates the locale, timezone and geolocation on a page before navigation
+func applyLocale(page *rod.Page, opts *renderOptions, targetURL string) error {
+	if opts.Locale != "" {
+		err := proto.EmulationSetLocaleOverride{Locale: strings.ReplaceAll(opts.Locale, "-", "_")}.Call(page)
+		if err != nil {
+			return fmt.Errorf("failed to set locale: %v", err)
+		}
+	}
+
+	if opts.Timezone != "" {
+		err := proto.EmulationSetTimezoneOverride{TimezoneID: opts.Timezone}.Call(page)
+		if err != nil {
+			return fmt.Errorf("failed to set timezone: %v", err)
+		}
+	}
+
+	if opts.Geolocation != nil {
+		// The page can only read the position once the permission is granted
+		if u, err := url.Parse(targetURL); err == nil {
+			err = proto.BrowserGrantPermissions{
+				Permissions:      []proto.BrowserPermissionType{proto.BrowserPermissionTypeGeolocation},
+				Origin:           u.Scheme + "://" + u.Host,
+				BrowserContextID: page.Browser().BrowserContextID,
+			}.Call(page.Browser())
+			if err != nil {
+				return fmt.Errorf("failed to grant geolocation permission: %v", err)
+			}
+		}
+
+		accuracy := opts.Geolocation.Accuracy
+		if accuracy == 0 {
+			accuracy = 100
+		}
+		err := proto.EmulationSetGeolocationOverride{
+			Latitude:  &opts.Geolocation.Latitude,
+			Longitude: &opts.Geolocation.Longitude,
+			Accuracy:  &accuracy,
+		}.Call(page)
+		if err != nil {
+			return fmt.Errorf("failed to set geolocation: %v", err)
+		}
+	}
+
+	return nil
+}
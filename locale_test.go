@@ -0,0 +1,152 @@
+package headlessproxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGeolocation(t *testing.T) {
+	tests := []struct {
+		value string
+		geo   *Geolocation
+	}{
+		{"52.52,13.405", &Geolocation{Latitude: 52.52, Longitude: 13.405}},
+		{" -33.87 , 151.21 , 25 ", &Geolocation{Latitude: -33.87, Longitude: 151.21, Accuracy: 25}},
+		{"90,-180,0", &Geolocation{Latitude: 90, Longitude: -180}},
+		// Malformed or out of range positions are rejected
+		{"", nil},
+		{"52.52", nil},
+		{"52.52,13.405,25,1", nil},
+		{"52.52;13.405", nil},
+		{"north,13.405", nil},
+		{"52.52,", nil},
+		{"91,0", nil},
+		{"0,180.5", nil},
+		{"0,0,-1", nil},
+	}
+	for _, tt := range tests {
+		geo, err := parseGeolocation(tt.value)
+		assert.Equal(t, tt.geo, geo, tt.value)
+		assert.Equal(t, tt.geo == nil, err != nil, tt.value)
+	}
+
+	// Parsing the formatted position gives it back
+	geo := &Geolocation{Latitude: -33.87, Longitude: 151.21, Accuracy: 25}
+	parsed, err := parseGeolocation(geo.String())
+	assert.NoError(t, err)
+	assert.Equal(t, geo, parsed)
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	tests := []struct {
+		acceptLanguage string
+		language       string
+	}{
+		{"de-DE", "de-DE"},
+		{"fr-CH, fr;q=0.9, en;q=0.8", "fr-CH"},
+		// The highest quality wins, wherever it is listed
+		{"en;q=0.5, de-DE;q=0.8, fr", "fr"},
+		{"en;q=0.5,de-DE;q=0.8", "de-DE"},
+		// Ties go to the first tag
+		{"nl;q=0.7, it;q=0.7", "nl"},
+		// A missing or malformed quality counts as 1
+		{"es;q=high, pt;q=0.9", "es"},
+		{"es;level=1, pt;q=0.9", "es"},
+		// Wildcards and excluded tags never win
+		{"*, en;q=0.1", "en"},
+		{"de;q=0, en;q=0.1", "en"},
+		{"de;q=0", ""},
+		{" , ;q=0.5", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.language, preferredLanguage(tt.acceptLanguage), tt.acceptLanguage)
+	}
+}
+
+func TestAcceptLanguageFor(t *testing.T) {
+	assert.Equal(t, "de-DE,de;q=0.9", acceptLanguageFor("de-DE"))
+	assert.Equal(t, "zh-Hant-TW,zh;q=0.9", acceptLanguageFor("zh-Hant-TW"))
+	assert.Equal(t, "de", acceptLanguageFor("de"))
+}
+
+func TestResolveLocale(t *testing.T) {
+	berlin := &Geolocation{Latitude: 52.52, Longitude: 13.405}
+	tests := []struct {
+		name           string
+		locale         string
+		acceptLanguage string
+		timezone       string
+		geolocation    string
+		opts           renderOptions
+	}{
+		{
+			name:           "no locale",
+			acceptLanguage: "fr-CH",
+			opts:           renderOptions{Timezone: "Europe/Berlin", Geolocation: berlin},
+		},
+		{
+			name:           "fixed locale",
+			locale:         "de-DE",
+			acceptLanguage: "fr-CH",
+			opts:           renderOptions{Locale: "de-DE", AcceptLanguage: "de-DE,de;q=0.9", Timezone: "Europe/Berlin", Geolocation: berlin},
+		},
+		{
+			name:           "auto with the client's preference",
+			locale:         "auto",
+			acceptLanguage: "en;q=0.5, fr-ch;q=0.8",
+			opts:           renderOptions{Locale: "fr-CH", AcceptLanguage: "en;q=0.5, fr-ch;q=0.8", Timezone: "Europe/Berlin", Geolocation: berlin},
+		},
+		{
+			name:           "auto with an invalid tag",
+			locale:         "auto",
+			acceptLanguage: "not a language, en;q=0.5",
+			opts:           renderOptions{AcceptLanguage: "not a language, en;q=0.5", Timezone: "Europe/Berlin", Geolocation: berlin},
+		},
+		{
+			name:   "auto without Accept-Language",
+			locale: "auto",
+			opts:   renderOptions{Timezone: "Europe/Berlin", Geolocation: berlin},
+		},
+		{
+			name:        "client timezone and position",
+			timezone:    "America/New_York",
+			geolocation: "40.71,-74.01,50",
+			opts: renderOptions{
+				Timezone:    "America/New_York",
+				Geolocation: &Geolocation{Latitude: 40.71, Longitude: -74.01, Accuracy: 50},
+			},
+		},
+		{
+			name:        "unknown timezone and malformed position",
+			timezone:    "Mars/Olympus_Mons",
+			geolocation: "40.71",
+			opts:        renderOptions{Timezone: "Europe/Berlin", Geolocation: berlin},
+		},
+	}
+	for _, tt := range tests {
+		h := &HeadlessProxy{
+			Locale:            tt.locale,
+			Timezone:          "Europe/Berlin",
+			TimezoneHeader:    "X-Timezone",
+			Geolocation:       berlin,
+			GeolocationHeader: "X-Geolocation",
+		}
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.acceptLanguage != "" {
+			r.Header.Set("Accept-Language", tt.acceptLanguage)
+		}
+		if tt.timezone != "" {
+			r.Header.Set("X-Timezone", tt.timezone)
+		}
+		if tt.geolocation != "" {
+			r.Header.Set("X-Geolocation", tt.geolocation)
+		}
+
+		var opts renderOptions
+		h.resolveLocale(r, &opts)
+		assert.Equal(t, tt.opts, opts, tt.name)
+	}
+}
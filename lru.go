@@ -0,0 +1,122 @@
+package headlessproxy
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// memoryCache is an in-memory CacheBackend that evicts the least recently
+// used entries once the total size exceeds its limit
+type memoryCache struct {
+	maxBytes int64
+
+	mu        sync.Mutex
+	entries   map[string]*list.Element
+	order     *list.List
+	bytes     int64
+	evictions int64
+}
+
+// lruItem is an entry in the recency list
+type lruItem struct {
+	key   string
+	entry *cacheEntry
+	size  int64
+}
+
+// newMemoryCache creates an LRU cache holding up to maxBytes
+func newMemoryCache(maxBytes int64) *memoryCache {
+	return &memoryCache{
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get returns an entry and marks it as recently used
+func (c *memoryCache) Get(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruItem).entry, true
+}
+
+// Set stores an entry, evicting the least recently used entries to make room.
+// Entries larger than the whole cache are not stored.
+func (c *memoryCache) Set(key string, entry *cacheEntry) error {
+	size := entry.size(key)
+	if size > c.maxBytes {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	// Hits are counted from zero for each stored response
+	stored := *entry
+	stored.hits = new(atomic.Int64)
+	c.entries[key] = c.order.PushFront(&lruItem{key: key, entry: &stored, size: size})
+	c.bytes += size
+
+	for c.bytes > c.maxBytes {
+		c.remove(c.order.Back())
+		c.evictions++
+	}
+	return nil
+}
+
+// Delete removes an entry
+func (c *memoryCache) Delete(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if ok {
+		c.remove(elem)
+	}
+	return ok
+}
+
+// Purge removes the entries match selects, or all entries if match is nil
+func (c *memoryCache) Purge(match func(key string, entry *cacheEntry) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for key, elem := range c.entries {
+		if match == nil || match(key, elem.Value.(*lruItem).entry) {
+			c.remove(elem)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Stats reports the number of entries, their size and the evictions so far
+func (c *memoryCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Entries:   len(c.entries),
+		Bytes:     c.bytes,
+		Evictions: c.evictions,
+	}
+}
+
+// remove drops an element; the lock must be held
+func (c *memoryCache) remove(elem *list.Element) {
+	item := elem.Value.(*lruItem)
+	c.order.Remove(elem)
+	delete(c.entries, item.key)
+	c.bytes -= item.size
+}
@@ -0,0 +1,47 @@
+package headlessproxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryCacheEviction(t *testing.T) {
+	entry := func(size int) *cacheEntry {
+		return &cacheEntry{Content: make([]byte, size)}
+	}
+
+	// Room for three 99-byte entries under one-byte keys
+	cache := newMemoryCache(300)
+	assert.NoError(t, cache.Set("a", entry(99)))
+	assert.NoError(t, cache.Set("b", entry(99)))
+	assert.NoError(t, cache.Set("c", entry(99)))
+
+	// Reading a makes b the least recently used entry
+	_, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.NoError(t, cache.Set("d", entry(99)))
+
+	_, ok = cache.Get("b")
+	assert.False(t, ok)
+	for _, key := range []string{"a", "c", "d"} {
+		_, ok = cache.Get(key)
+		assert.True(t, ok, key)
+	}
+	assert.Equal(t, CacheStats{Entries: 3, Bytes: 300, Evictions: 1}, cache.Stats())
+
+	// Replacing an entry updates the size instead of adding to it
+	assert.NoError(t, cache.Set("a", entry(49)))
+	assert.Equal(t, int64(250), cache.Stats().Bytes)
+
+	// Entries larger than the cache are not stored
+	assert.NoError(t, cache.Set("e", entry(1000)))
+	_, ok = cache.Get("e")
+	assert.False(t, ok)
+
+	assert.True(t, cache.Delete("c"))
+	assert.False(t, cache.Delete("c"))
+	assert.Equal(t, 1, cache.Purge(func(key string, _ *cacheEntry) bool { return key == "d" }))
+	assert.Equal(t, 1, cache.Purge(nil))
+	assert.Equal(t, CacheStats{Evictions: 1}, cache.Stats())
+}
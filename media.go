@@ -0,0 +1,91 @@
+package headlessproxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// mediaClientHints are the user preference hints requested from clients
+var mediaClientHints = []string{"Sec-CH-Prefers-Color-Scheme", "Sec-CH-Prefers-Reduced-Motion"}
+
+// mediaFeatureValues lists the values accepted for each emulated media feature
+var mediaFeatureValues = map[string][]string{
+	"prefers-color-scheme":   {"light", "dark"},
+	"prefers-reduced-motion": {"no-preference", "reduce"},
+	"forced-colors":          {"none", "active"},
+	"media":                  {"screen", "print"},
+}
+
+// validMediaFeature reports whether value is accepted for a media feature
+func validMediaFeature(feature, value string) bool {
+	for _, v := range mediaFeatureValues[feature] {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveMedia fills in the CSS media type and features of the render options
+func (h *HeadlessProxy) resolveMedia(r *http.Request, opts *renderOptions) {
+	opts.ColorScheme = h.ColorScheme
+	opts.ReducedMotion = h.ReducedMotion
+	opts.ForcedColors = h.ForcedColors
+	opts.Media = h.Media
+
+	if !h.MediaClientHints {
+		return
+	}
+
+	// Hints are structured header strings, e.g. Sec-CH-Prefers-Color-Scheme: "dark"
+	if v := unquoteClientHint(r.Header.Get("Sec-CH-Prefers-Color-Scheme")); validMediaFeature("prefers-color-scheme", v) {
+		opts.ColorScheme = v
+	}
+	if v := unquoteClientHint(r.Header.Get("Sec-CH-Prefers-Reduced-Motion")); validMediaFeature("prefers-reduced-motion", v) {
+		opts.ReducedMotion = v
+	}
+}
+
+// mediaVary returns the request headers the emulated media depends on
+func (h *HeadlessProxy) mediaVary() []string {
+	if !h.MediaClientHints {
+		return nil
+	}
+	return mediaClientHints
+}
+
+// mediaKey describes the emulated media for the cache key
+func (opts *renderOptions) mediaKey() string {
+	if opts.Media == "" && opts.ColorScheme == "" && opts.ReducedMotion == "" && opts.ForcedColors == "" {
+		return ""
+	}
+	return strings.Join([]string{opts.Media, opts.ColorScheme, opts.ReducedMotion, opts.ForcedColors}, ",")
+}
+
+// applyMedia emulates the CSS media type and features on a page before navigation
+func applyMedia(page *rod.Page, opts *renderOptions) error {
+	if opts.mediaKey() == "" {
+		return nil
+	}
+
+	var features []*proto.EmulationMediaFeature
+	if opts.ColorScheme != "" {
+		features = append(features, &proto.EmulationMediaFeature{Name: "prefers-color-scheme", Value: opts.ColorScheme})
+	}
+	if opts.ReducedMotion != "" {
+		features = append(features, &proto.EmulationMediaFeature{Name: "prefers-reduced-motion", Value: opts.ReducedMotion})
+	}
+	if opts.ForcedColors != "" {
+		features = append(features, &proto.EmulationMediaFeature{Name: "forced-colors", Value: opts.ForcedColors})
+	}
+
+	err := proto.EmulationSetEmulatedMedia{Media: opts.Media, Features: features}.Call(page)
+	if err != nil {
+		return fmt.Errorf("failed to set emulated media: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package headlessproxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidMediaFeature(t *testing.T) {
+	assert.True(t, validMediaFeature("prefers-color-scheme", "dark"))
+	assert.True(t, validMediaFeature("media", "print"))
+	assert.False(t, validMediaFeature("prefers-color-scheme", "sepia"))
+	assert.False(t, validMediaFeature("prefers-color-scheme", "Dark"))
+	assert.False(t, validMediaFeature("prefers-color-scheme", ""))
+	assert.False(t, validMediaFeature("prefers-contrast", "more"))
+}
+
+func TestResolveMedia(t *testing.T) {
+	tests := []struct {
+		name          string
+		clientHints   bool
+		colorScheme   string
+		reducedMotion string
+		opts          renderOptions
+	}{
+		{
+			name: "configured only",
+			opts: renderOptions{Media: "screen", ColorScheme: "light"},
+		},
+		{
+			name:          "hints ignored when not requested",
+			colorScheme:   `"dark"`,
+			reducedMotion: `"reduce"`,
+			opts:          renderOptions{Media: "screen", ColorScheme: "light"},
+		},
+		{
+			name:          "client preferences",
+			clientHints:   true,
+			colorScheme:   `"dark"`,
+			reducedMotion: `reduce`,
+			opts:          renderOptions{Media: "screen", ColorScheme: "dark", ReducedMotion: "reduce"},
+		},
+		{
+			name:          "unknown values keep the configuration",
+			clientHints:   true,
+			colorScheme:   `"sepia"`,
+			reducedMotion: `"always"`,
+			opts:          renderOptions{Media: "screen", ColorScheme: "light"},
+		},
+	}
+	for _, tt := range tests {
+		h := &HeadlessProxy{Media: "screen", ColorScheme: "light", MediaClientHints: tt.clientHints}
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.colorScheme != "" {
+			r.Header.Set("Sec-CH-Prefers-Color-Scheme", tt.colorScheme)
+		}
+		if tt.reducedMotion != "" {
+			r.Header.Set("Sec-CH-Prefers-Reduced-Motion", tt.reducedMotion)
+		}
+
+		var opts renderOptions
+		h.resolveMedia(r, &opts)
+		assert.Equal(t, tt.opts, opts, tt.name)
+	}
+}
+
+func TestMediaKey(t *testing.T) {
+	assert.Equal(t, "", (&renderOptions{}).mediaKey())
+	assert.Equal(t, "print,,,", (&renderOptions{Media: "print"}).mediaKey())
+	assert.Equal(t, ",dark,reduce,active", (&renderOptions{ColorScheme: "dark", ReducedMotion: "reduce", ForcedColors: "active"}).mediaKey())
+
+	// Each feature keeps its position, so different settings never share a key
+	assert.NotEqual(t, (&renderOptions{ColorScheme: "dark"}).mediaKey(), (&renderOptions{ColorScheme: "light"}).mediaKey())
+	assert.NotEqual(t, (&renderOptions{ReducedMotion: "reduce"}).mediaKey(), (&renderOptions{ForcedColors: "reduce"}).mediaKey())
+}
+
+func TestMediaCacheKey(t *testing.T) {
+	h := &HeadlessProxy{MediaClientHints: true}
+	cacheKey := func(colorScheme string) string {
+		r := httptest.NewRequest("GET", "/page", nil)
+		if colorScheme != "" {
+			r.Header.Set("Sec-CH-Prefers-Color-Scheme", colorScheme)
+		}
+		opts := &renderOptions{Format: "html"}
+		h.resolveMedia(r, opts)
+		return h.getCacheKey(withRenderOptions(r, opts))
+	}
+
+	// Each preference is cached separately; rejected values share the default render
+	assert.NotEqual(t, cacheKey(`"dark"`), cacheKey(`"light"`))
+	assert.NotEqual(t, cacheKey(`"dark"`), cacheKey(""))
+	assert.Equal(t, cacheKey(`"sepia"`), cacheKey(""))
+
+	// The hints are only part of Vary when they are used
+	assert.Equal(t, []string{"Sec-CH-Prefers-Color-Scheme", "Sec-CH-Prefers-Reduced-Motion"}, h.mediaVary())
+	h.MediaClientHints = false
+	assert.Empty(t, h.mediaVary())
+}
@@ -18,8 +18,14 @@ type Metrics struct {
 	responseStatusCode *prometheus.CounterVec
 
 	// Cache metrics
-	cacheHits   prometheus.Counter
-	cacheMisses prometheus.Counter
+	cacheHits               prometheus.Counter
+	cacheMisses             prometheus.Counter
+	cacheEntries            prometheus.GaugeFunc
+	cacheBytes              prometheus.GaugeFunc
+	cacheEvictions          prometheus.CounterFunc
+	cacheStaleTotal         *prometheus.CounterVec
+	coalescedRequestsTotal  prometheus.Counter
+	cacheRevalidationsTotal *prometheus.CounterVec
 
 	// Browser metrics
 	browserPoolSize      prometheus.Gauge
@@ -29,6 +35,23 @@ type Metrics struct {
 	browserErrorsTotal   *prometheus.CounterVec
 	browserResourcesUsed *prometheus.GaugeVec
 
+	// Page metrics
+	pageMessagesTotal    *prometheus.CounterVec
+	blockedRequestsTotal *prometheus.CounterVec
+	mockedRequestsTotal  prometheus.Counter
+
+	// Egress proxy metrics
+	browserProxyUp *prometheus.GaugeVec
+
+	// Render diff metrics
+	renderDiffSamples  *prometheus.CounterVec
+	renderDiffTextGain *prometheus.GaugeVec
+
+	// Cache warmer metrics
+	cacheWarmURLsTotal *prometheus.CounterVec
+	cacheWarmPending   prometheus.Gauge
+	cacheWarmLastRun   prometheus.Gauge
+
 	// Resource optimization metrics
 	optimizationSavings prometheus.Counter
 
@@ -97,6 +120,53 @@ func (h *HeadlessProxy) initMetrics() {
 			},
 		)
 
+		h.metrics.cacheEntries = promauto.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Name: "caddy_headless_proxy_cache_entries",
+				Help: "Number of responses in the cache",
+			},
+			func() float64 { return float64(h.cacheStats().Entries) },
+		)
+
+		h.metrics.cacheBytes = promauto.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Name: "caddy_headless_proxy_cache_bytes",
+				Help: "Total size of the responses in the cache",
+			},
+			func() float64 { return float64(h.cacheStats().Bytes) },
+		)
+
+		h.metrics.cacheEvictions = promauto.NewCounterFunc(
+			prometheus.CounterOpts{
+				Name: "caddy_headless_proxy_cache_evictions_total",
+				Help: "Total number of responses evicted from the cache to stay within its size limit",
+			},
+			func() float64 { return float64(h.cacheStats().Evictions) },
+		)
+
+		h.metrics.cacheStaleTotal = promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "caddy_headless_proxy_cache_stale_total",
+				Help: "Total number of expired responses served, by reason (revalidate, error)",
+			},
+			[]string{"reason"},
+		)
+
+		h.metrics.coalescedRequestsTotal = promauto.NewCounter(
+			prometheus.CounterOpts{
+				Name: "caddy_headless_proxy_coalesced_requests_total",
+				Help: "Total number of requests that waited for an identical render in flight instead of rendering",
+			},
+		)
+
+		h.metrics.cacheRevalidationsTotal = promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "caddy_headless_proxy_cache_revalidations_total",
+				Help: "Total number of conditional upstream requests for expired responses, by result (not_modified, modified, error)",
+			},
+			[]string{"result"},
+		)
+
 		// Browser metrics
 		h.metrics.browserPoolSize = promauto.NewGauge(
 			prometheus.GaugeOpts{
@@ -143,6 +213,77 @@ func (h *HeadlessProxy) initMetrics() {
 			[]string{"resource_type"},
 		)
 
+		// Page metrics
+		h.metrics.pageMessagesTotal = promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "caddy_headless_proxy_page_messages_total",
+				Help: "Total number of console messages, exceptions and failed loads seen during renders",
+			},
+			[]string{"level"},
+		)
+		h.metrics.blockedRequestsTotal = promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "caddy_headless_proxy_blocked_requests_total",
+				Help: "Total number of requests blocked during renders, by resource type",
+			},
+			[]string{"type"},
+		)
+		h.metrics.mockedRequestsTotal = promauto.NewCounter(
+			prometheus.CounterOpts{
+				Name: "caddy_headless_proxy_mocked_requests_total",
+				Help: "Total number of requests answered by mock rules during renders",
+			},
+		)
+
+		// Egress proxy metrics
+		h.metrics.browserProxyUp = promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "caddy_headless_proxy_browser_proxy_up",
+				Help: "Whether an egress proxy passed its last health check",
+			},
+			[]string{"proxy"},
+		)
+
+		// Render diff metrics
+		h.metrics.renderDiffSamples = promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "caddy_headless_proxy_render_diff_samples_total",
+				Help: "Total number of rendered pages compared against the raw upstream HTML",
+			},
+			[]string{"prefix"},
+		)
+
+		h.metrics.renderDiffTextGain = promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "caddy_headless_proxy_render_diff_text_gain_ratio",
+				Help: "Average share of page text that only exists after rendering",
+			},
+			[]string{"prefix"},
+		)
+
+		// Cache warmer metrics
+		h.metrics.cacheWarmURLsTotal = promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "caddy_headless_proxy_cache_warm_urls_total",
+				Help: "Total number of sitemap URLs processed by the cache warmer",
+			},
+			[]string{"result"},
+		)
+
+		h.metrics.cacheWarmPending = promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "caddy_headless_proxy_cache_warm_pending",
+				Help: "Number of sitemap URLs left in the current warming run",
+			},
+		)
+
+		h.metrics.cacheWarmLastRun = promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "caddy_headless_proxy_cache_warm_last_run_timestamp_seconds",
+				Help: "Time the last warming run finished",
+			},
+		)
+
 		// Resource optimization metrics
 		h.metrics.optimizationSavings = promauto.NewCounter(
 			prometheus.CounterOpts{
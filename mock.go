@@ -0,0 +1,94 @@
+package headlessproxy
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/go-rod/rod"
+)
+
+// MockRule fulfills requests matching a URL pattern with a canned response
+type MockRule struct {
+	// URL pattern where * matches any characters and ^ a separator, e.g. "https://widgets.example.com/*"
+	Pattern string `json:"pattern"`
+
+	// File whose content is the response body
+	File string `json:"file,omitempty"`
+
+	// Inline response body
+	Body string `json:"body,omitempty"`
+
+	// Response status (200 with a body, 204 without)
+	Status int `json:"status,omitempty"`
+
+	// Response headers
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// mockResponse is a mock rule ready to be served
+type mockResponse struct {
+	pattern string
+	status  int
+	headers []string
+	body    []byte
+}
+
+// loadMocks reads the files of the mock rules and prepares their responses
+func loadMocks(rules []MockRule) ([]*mockResponse, error) {
+	mocks := make([]*mockResponse, 0, len(rules))
+	for _, rule := range rules {
+		m := &mockResponse{pattern: rule.Pattern, status: rule.Status, body: []byte(rule.Body)}
+
+		contentType := ""
+		if rule.File != "" {
+			body, err := os.ReadFile(rule.File)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read mock file: %v", err)
+			}
+			m.body = body
+			contentType = mime.TypeByExtension(filepath.Ext(rule.File))
+		}
+
+		if m.status == 0 {
+			m.status = http.StatusOK
+			if len(m.body) == 0 {
+				m.status = http.StatusNoContent
+			}
+		}
+
+		for name, value := range rule.Headers {
+			if http.CanonicalHeaderKey(name) == "Content-Type" {
+				contentType = ""
+			}
+			m.headers = append(m.headers, name, value)
+		}
+		if contentType != "" {
+			m.headers = append(m.headers, "Content-Type", contentType)
+		}
+
+		mocks = append(mocks, m)
+	}
+	return mocks, nil
+}
+
+// matchMock returns the first mock whose pattern matches the URL
+func (h *HeadlessProxy) matchMock(rawURL string) *mockResponse {
+	for _, m := range h.mocks {
+		if wildcardMatch(m.pattern, rawURL) {
+			return m
+		}
+	}
+	return nil
+}
+
+// fulfill answers a hijacked request with the mock response
+func (m *mockResponse) fulfill(ctx *rod.Hijack) {
+	ctx.Response.Payload().ResponseCode = m.status
+	if len(m.headers) > 0 {
+		ctx.Response.SetHeader(m.headers...)
+	}
+	ctx.Response.SetBody(m.body)
+}
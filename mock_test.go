@@ -0,0 +1,97 @@
+package headlessproxy
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMocks(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "widget.html")
+	require.NoError(t, os.WriteFile(file, []byte("<div>widget</div>"), 0o644))
+
+	tests := []struct {
+		name        string
+		rule        MockRule
+		status      int
+		body        string
+		contentType string
+	}{
+		{"empty", MockRule{Pattern: "*"}, http.StatusNoContent, "", ""},
+		{"inline body", MockRule{Pattern: "*", Body: "{}"}, http.StatusOK, "{}", ""},
+		{"explicit status", MockRule{Pattern: "*", Status: http.StatusNotFound}, http.StatusNotFound, "", ""},
+		{"file", MockRule{Pattern: "*", File: file}, http.StatusOK, "<div>widget</div>", "text/html"},
+		{"explicit type", MockRule{Pattern: "*", File: file, Headers: map[string]string{"content-type": "text/plain"}}, http.StatusOK, "<div>widget</div>", "text/plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mocks, err := loadMocks([]MockRule{tt.rule})
+			require.NoError(t, err)
+			require.Len(t, mocks, 1)
+
+			m := mocks[0]
+			assert.Equal(t, tt.status, m.status)
+			assert.Equal(t, tt.body, string(m.body))
+
+			// Headers are name and value pairs
+			contentType := ""
+			for i := 0; i+1 < len(m.headers); i += 2 {
+				if http.CanonicalHeaderKey(m.headers[i]) == "Content-Type" {
+					assert.Empty(t, contentType, "Content-Type set twice")
+					contentType = m.headers[i+1]
+				}
+			}
+			if tt.contentType == "" {
+				assert.Empty(t, contentType)
+			} else {
+				assert.Contains(t, contentType, tt.contentType)
+			}
+		})
+	}
+
+	_, err := loadMocks([]MockRule{{Pattern: "*", File: filepath.Join(dir, "missing.js")}})
+	assert.Error(t, err)
+}
+
+func TestMatchMock(t *testing.T) {
+	mocks, err := loadMocks([]MockRule{
+		{Pattern: "https://widgets.example.com/*", Body: "widgets"},
+		{Pattern: "https://site.test/api/config", Body: "config"},
+		{Pattern: "*/lib.js^*", Body: "lib"},
+	})
+	require.NoError(t, err)
+	h := &HeadlessProxy{mocks: mocks}
+
+	tests := []struct {
+		url  string
+		body string
+	}{
+		{"https://widgets.example.com/embed.js", "widgets"},
+		// Patterns are anchored at both ends
+		{"https://site.test/api/config", "config"},
+		{"https://site.test/api/config?v=2", ""},
+		{"https://mirror.test/https://site.test/api/config", ""},
+		// ^ matches a separator or the end of the URL
+		{"https://cdn.test/lib.js", "lib"},
+		{"https://cdn.test/lib.js?v=1", "lib"},
+		{"https://cdn.test/lib.jsx", ""},
+		// Unlike filter lists, patterns are case-sensitive
+		{"https://Widgets.example.com/embed.js", ""},
+		{"https://site.test/API/config", ""},
+	}
+	for _, tt := range tests {
+		m := h.matchMock(tt.url)
+		if tt.body == "" {
+			assert.Nil(t, m, tt.url)
+			continue
+		}
+		if assert.NotNil(t, m, tt.url) {
+			assert.Equal(t, tt.body, string(m.body), tt.url)
+		}
+	}
+}
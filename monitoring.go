@@ -56,6 +56,52 @@ func (m *BrowserMonitor) collectBrowserMetrics() {
 	}
 }
 
+// RecycleBrowsers closes the given browsers and replaces them in the pool with
+// new ones. Browsers rendering meanwhile are not in the pool; returnBrowser
+// closes them once their render is done. Browsers are only swapped under the
+// pool lock, so renders don't wait for them to close or launch.
+func (m *BrowserMonitor) RecycleBrowsers(browsers []*rod.Browser) {
+	recycle := make(map[*rod.Browser]bool, len(browsers))
+	for _, browser := range browsers {
+		recycle[browser] = true
+	}
+
+	var retired []*rod.Browser
+	m.proxy.browserPoolLock.Lock()
+	pool := m.proxy.browserPool[:0]
+	for _, browser := range m.proxy.browserPool {
+		if recycle[browser] {
+			retired = append(retired, browser)
+		} else {
+			pool = append(pool, browser)
+		}
+	}
+	m.proxy.browserPool = pool
+	m.proxy.browserPoolLock.Unlock()
+
+	var replacements []*rod.Browser
+	for _, browser := range retired {
+		m.proxy.logger.Info("recycling browser behind unhealthy proxy")
+		if err := browser.Close(); err != nil {
+			m.proxy.logger.Error("failed to close browser", zap.Error(err))
+		}
+		m.proxy.metrics.browserClosedTotal.Inc()
+		if m.proxy.proxyPool != nil {
+			m.proxy.proxyPool.Release(browser)
+		}
+
+		// A failed launch leaves the pool smaller; getBrowser launches on demand
+		if newBrowser := m.proxy.createBrowser(); newBrowser != nil {
+			replacements = append(replacements, newBrowser)
+			m.proxy.metrics.browserCreatedTotal.Inc()
+		}
+	}
+
+	m.proxy.browserPoolLock.Lock()
+	m.proxy.browserPool = append(m.proxy.browserPool, replacements...)
+	m.proxy.browserPoolLock.Unlock()
+}
+
 // collectMetricsFromBrowser collects metrics from a single browser
 func (m *BrowserMonitor) collectMetricsFromBrowser(browser *rod.Browser) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -0,0 +1,145 @@
+package headlessproxy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// outputFormats maps each output format to the media type it is served as
+var outputFormats = map[string]string{
+	"html":        "text/html; charset=utf-8",
+	"har":         "application/json",
+	"seo-report":  "application/json",
+	"diff-report": "application/json",
+	"png":         "image/png",
+	"webp":        "image/webp",
+	"pdf":         "application/pdf",
+	"json":        "application/json",
+	"markdown":    "text/markdown; charset=utf-8",
+	"performance": "application/json",
+}
+
+// renderOptions holds the settings resolved for a single request
+type renderOptions struct {
+	// Output format of the response
+	Format string
+
+	// Name and settings of the emulated device, if any
+	Profile string
+	Device  *EmulationProfile
+
+	// Locale, Accept-Language, timezone and position presented to the page
+	Locale         string
+	AcceptLanguage string
+	Timezone       string
+	Geolocation    *Geolocation
+
+	// CSS media type and user preference media features
+	Media         string
+	ColorScheme   string
+	ReducedMotion string
+	ForcedColors  string
+
+	// Name and settings of the emulated network and CPU conditions, if any
+	Throttle   string
+	Conditions *ThrottleProfile
+
+	// Element to wait for, render timeout and cache bypass from signed overrides
+	WaitFor string
+	Timeout time.Duration
+	NoCache bool
+
+	// User-Agent and Client Hints presented to the page and upstream
+	UserAgent         string
+	UserAgentMetadata *proto.EmulationUserAgentMetadata
+}
+
+type renderOptionsCtxKey struct{}
+
+// withRenderOptions attaches render options to a request
+func withRenderOptions(r *http.Request, opts *renderOptions) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), renderOptionsCtxKey{}, opts))
+}
+
+// getRenderOptions returns the render options of a request, falling back to the configured defaults
+func (h *HeadlessProxy) getRenderOptions(r *http.Request) *renderOptions {
+	if opts, ok := r.Context().Value(renderOptionsCtxKey{}).(*renderOptions); ok {
+		return opts
+	}
+	return &renderOptions{Format: h.Output}
+}
+
+// acceptRange is a single media range of an Accept header
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept parses the media ranges of an Accept header
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaType == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			name, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if found && strings.EqualFold(name, "q") {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+	return ranges
+}
+
+// mediaRangeMatches reports whether a media range matches a media type
+func mediaRangeMatches(mediaRange, mediaType string) bool {
+	mediaType, _, _ = strings.Cut(mediaType, ";")
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+	if strings.HasSuffix(mediaRange, "/*") {
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*"))
+	}
+	return false
+}
+
+// negotiateFormat picks the allowed output format that best matches an Accept header.
+// Each format takes the quality of the most specific range matching it; formats
+// earlier in allowed win ties.
+func negotiateFormat(accept string, allowed []string) (string, bool) {
+	if len(allowed) == 0 {
+		return "", false
+	}
+	if strings.TrimSpace(accept) == "" {
+		return allowed[0], true
+	}
+
+	ranges := parseAccept(accept)
+	best, bestQ := "", 0.0
+	for _, format := range allowed {
+		q, specificity := 0.0, -1
+		for _, ar := range ranges {
+			s := 2 - strings.Count(ar.mediaType, "*")
+			if s > specificity && mediaRangeMatches(ar.mediaType, outputFormats[format]) {
+				q, specificity = ar.q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best, best != ""
+}
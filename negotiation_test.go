@@ -0,0 +1,47 @@
+package headlessproxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAccept(t *testing.T) {
+	assert.Equal(t, []acceptRange{
+		{mediaType: "image/*", q: 0.8},
+		{mediaType: "text/html", q: 0.9},
+		{mediaType: "*/*", q: 1},
+		{mediaType: "application/json", q: 1},
+	}, parseAccept("image/*;q=0.8, Text/HTML ; Q=0.9,*/*,,application/json;q=high"))
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept  string
+		allowed []string
+		format  string
+		ok      bool
+	}{
+		// Higher quality wins
+		{"image/*;q=0.8,text/html;q=0.9", []string{"png", "html"}, "html", true},
+		{"image/*;q=0.9,text/html;q=0.8", []string{"html", "png"}, "png", true},
+		// Ties go to the format allowed first
+		{"*/*", []string{"png", "html"}, "png", true},
+		{"application/json", []string{"html", "har", "json"}, "har", true},
+		{"", []string{"markdown", "html"}, "markdown", true},
+		// The most specific matching range sets the quality
+		{"text/*;q=0.3,text/markdown", []string{"html", "markdown"}, "markdown", true},
+		{"text/html;q=0,*/*;q=0.1", []string{"html", "pdf"}, "pdf", true},
+		{"image/webp,image/*;q=0", []string{"png", "webp"}, "webp", true},
+		// q=0 rules a format out
+		{"image/png;q=0", []string{"png"}, "", false},
+		{"*/*;q=0", []string{"html", "png"}, "", false},
+		{"application/xml", []string{"html"}, "", false},
+		{"text/html", nil, "", false},
+	}
+	for _, tt := range tests {
+		format, ok := negotiateFormat(tt.accept, tt.allowed)
+		assert.Equal(t, tt.format, format, tt.accept)
+		assert.Equal(t, tt.ok, ok, tt.accept)
+	}
+}
@@ -2,6 +2,7 @@ package headlessproxy
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"regexp"
@@ -276,6 +277,10 @@ func (o *ResourceOptimizer) OptimizeResponse(contentType string, content []byte)
 	var output bytes.Buffer
 	r := bytes.NewReader(content)
 	err := o.minifier.Minify(contentType, &output, r)
+	if errors.Is(err, minify.ErrNotExist) {
+		// No minifier for this content type, e.g. text/markdown
+		return content, nil
+	}
 	if err != nil {
 		return content, err
 	}
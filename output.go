@@ -0,0 +1,147 @@
+package headlessproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// pageExtract is the structured content returned by the json output format
+type pageExtract struct {
+	URL         string             `json:"url"`
+	Title       string             `json:"title"`
+	Description string             `json:"description"`
+	Lang        string             `json:"lang"`
+	Canonical   string             `json:"canonical"`
+	Headings    []seoHeading       `json:"headings"`
+	Text        string             `json:"text"`
+	Links       []pageExtractLink  `json:"links"`
+	Images      []pageExtractImage `json:"images"`
+}
+
+type pageExtractLink struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+type pageExtractImage struct {
+	Src string `json:"src"`
+	Alt string `json:"alt"`
+}
+
+// extractScript collects the main content of the rendered document
+const extractScript = `() => {
+	const clean = (s) => (s || '').trim().replace(/\s+/g, ' ');
+	const meta = document.querySelector('meta[name="description" i]');
+	const canonical = document.querySelector('link[rel="canonical" i]');
+	return {
+		url: location.href,
+		title: document.title || '',
+		description: meta ? (meta.getAttribute('content') || '') : '',
+		lang: document.documentElement.getAttribute('lang') || '',
+		canonical: canonical ? canonical.href : '',
+		headings: Array.from(document.querySelectorAll('h1, h2, h3, h4, h5, h6')).map(el => ({
+			level: parseInt(el.tagName.substring(1), 10), text: clean(el.textContent)
+		})),
+		text: document.body ? document.body.innerText : '',
+		links: Array.from(document.querySelectorAll('a[href]')).map(a => ({ href: a.href, text: clean(a.textContent) })),
+		images: Array.from(document.querySelectorAll('img[src]')).map(img => ({ src: img.src, alt: img.getAttribute('alt') || '' }))
+	};
+}`
+
+// markdownScript converts the rendered document body to Markdown
+const markdownScript = `() => {
+	const inline = (node) => Array.from(node.childNodes).map(convert).join('');
+	const block = (s) => '\n\n' + s.trim() + '\n\n';
+	const convert = (node) => {
+		if (node.nodeType === 3) return node.textContent.replace(/\s+/g, ' ');
+		if (node.nodeType !== 1) return '';
+		const tag = node.tagName.toLowerCase();
+		if (['script', 'style', 'noscript', 'template', 'svg', 'head'].includes(tag)) return '';
+		switch (tag) {
+			case 'h1': case 'h2': case 'h3': case 'h4': case 'h5': case 'h6':
+				return block('#'.repeat(parseInt(tag[1], 10)) + ' ' + inline(node));
+			case 'p': return block(inline(node));
+			case 'br': return '  \n';
+			case 'hr': return block('---');
+			case 'strong': case 'b': return '**' + inline(node).trim() + '**';
+			case 'em': case 'i': return '_' + inline(node).trim() + '_';
+			case 'code': return node.closest('pre') ? node.textContent : '` + "`" + `' + node.textContent + '` + "`" + `';
+			case 'pre': return block('` + "```" + `\n' + node.textContent.replace(/\n$/, '') + '\n` + "```" + `');
+			case 'a': {
+				const text = inline(node).trim();
+				return node.href ? '[' + text + '](' + node.href + ')' : text;
+			}
+			case 'img': return node.src ? '![' + (node.getAttribute('alt') || '') + '](' + node.src + ')' : '';
+			case 'blockquote': return block(inline(node).trim().split('\n').map(l => '> ' + l).join('\n'));
+			case 'ul': case 'ol': {
+				const ordered = tag === 'ol';
+				const items = Array.from(node.children).filter(li => li.tagName.toLowerCase() === 'li');
+				return block(items.map((li, i) => (ordered ? (i + 1) + '. ' : '- ') + inline(li).trim().replace(/\n+/g, '\n   ')).join('\n'));
+			}
+			case 'table': {
+				const rows = Array.from(node.querySelectorAll('tr')).map(tr =>
+					'| ' + Array.from(tr.children).map(c => inline(c).trim().replace(/\|/g, '\\|')).join(' | ') + ' |');
+				if (rows.length === 0) return '';
+				const cols = node.querySelector('tr').children.length;
+				rows.splice(1, 0, '|' + ' --- |'.repeat(cols));
+				return block(rows.join('\n'));
+			}
+			case 'div': case 'section': case 'article': case 'main': case 'header': case 'footer': case 'nav': case 'aside':
+				return block(inline(node));
+			default: return inline(node);
+		}
+	};
+	return convert(document.body || document.documentElement).replace(/\n{3,}/g, '\n\n').trim() + '\n';
+}`
+
+// renderOutput produces the content of a non-HTML output format from a rendered page
+func (h *HeadlessProxy) renderOutput(page *rod.Page, format string) ([]byte, error) {
+	switch format {
+	case "png", "webp":
+		screenshotFormat := proto.PageCaptureScreenshotFormatPng
+		if format == "webp" {
+			screenshotFormat = proto.PageCaptureScreenshotFormatWebp
+		}
+		content, err := page.Screenshot(true, &proto.PageCaptureScreenshot{Format: screenshotFormat})
+		if err != nil {
+			return nil, fmt.Errorf("failed to capture screenshot: %v", err)
+		}
+		return content, nil
+
+	case "pdf":
+		reader, err := page.PDF(&proto.PagePrintToPDF{PrintBackground: true})
+		if err != nil {
+			return nil, fmt.Errorf("failed to print PDF: %v", err)
+		}
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PDF: %v", err)
+		}
+		return content, nil
+
+	case "json":
+		obj, err := page.Eval(extractScript)
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract page content: %v", err)
+		}
+		var extract pageExtract
+		if err := obj.Value.Unmarshal(&extract); err != nil {
+			return nil, fmt.Errorf("failed to decode page content: %v", err)
+		}
+		return json.Marshal(extract)
+
+	case "markdown":
+		obj, err := page.Eval(markdownScript)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert page to markdown: %v", err)
+		}
+		return []byte(obj.Value.Str()), nil
+
+	default:
+		return nil, fmt.Errorf("unsupported output format: %s", format)
+	}
+}
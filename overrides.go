@@ -0,0 +1,198 @@
+package headlessproxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// overrideHeaderPrefix and overrideQueryPrefix mark per-request render overrides
+	overrideHeaderPrefix = "x-headless-"
+	overrideQueryPrefix  = "headless-"
+)
+
+// renderOverrides are the settings a signed request may override
+type renderOverrides struct {
+	Format  string
+	Width   int
+	Height  int
+	WaitFor string
+	Timeout time.Duration
+	NoCache bool
+}
+
+// collectOverrides gathers X-Headless-* headers and headless-* query parameters,
+// keyed by their lowercase name without the prefix. Query parameters win.
+func collectOverrides(r *http.Request) map[string]string {
+	params := make(map[string]string)
+	for name, values := range r.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, overrideHeaderPrefix) && len(values) > 0 {
+			params[strings.TrimPrefix(lower, overrideHeaderPrefix)] = values[0]
+		}
+	}
+	for name, values := range r.URL.Query() {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, overrideQueryPrefix) && len(values) > 0 {
+			params[strings.TrimPrefix(lower, overrideQueryPrefix)] = values[0]
+		}
+	}
+	return params
+}
+
+// stripOverrideQuery returns the request without headless-* query parameters,
+// keeping the order of the others, so they reach neither upstream nor the cache key
+func stripOverrideQuery(r *http.Request) *http.Request {
+	if r.URL.RawQuery == "" {
+		return r
+	}
+
+	var kept []string
+	stripped := false
+	for _, pair := range strings.Split(r.URL.RawQuery, "&") {
+		if strings.HasPrefix(strings.ToLower(pair), overrideQueryPrefix) {
+			stripped = true
+			continue
+		}
+		kept = append(kept, pair)
+	}
+	if !stripped {
+		return r
+	}
+
+	r2 := r.Clone(r.Context())
+	r2.URL.RawQuery = strings.Join(kept, "&")
+	r2.RequestURI = r2.URL.RequestURI()
+	return r2
+}
+
+// overrideSignature signs the method, the request URI without overrides, the
+// expiry and the sorted name=value override pairs with HMAC-SHA256
+func overrideSignature(secret, method, requestURI string, params map[string]string) string {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		if name != "signature" && name != "expires" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	lines := []string{method, requestURI, params["expires"]}
+	for _, name := range names {
+		lines = append(lines, name+"="+params[name])
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// parseOverrides verifies and parses the render overrides of a request. The
+// request must already be stripped of override query parameters. Overrides
+// are ignored when no secret is configured.
+func (h *HeadlessProxy) parseOverrides(r *http.Request, params map[string]string) (*renderOverrides, error) {
+	if len(params) == 0 || h.OverrideSecret == "" {
+		return nil, nil
+	}
+
+	signature, expires := params["signature"], params["expires"]
+	if signature == "" || expires == "" {
+		return nil, fmt.Errorf("%w: signature and expires are required", ErrOverrideSignature)
+	}
+	expiry, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid expires: %v", ErrOverrideSignature, err)
+	}
+	if time.Now().Unix() > expiry {
+		return nil, fmt.Errorf("%w: expired", ErrOverrideSignature)
+	}
+	expected := overrideSignature(h.OverrideSecret, r.Method, r.URL.RequestURI(), params)
+	if !hmac.Equal([]byte(strings.ToLower(signature)), []byte(expected)) {
+		return nil, fmt.Errorf("%w: signature mismatch", ErrOverrideSignature)
+	}
+
+	overrides := &renderOverrides{}
+	for name, value := range params {
+		switch name {
+		case "signature", "expires":
+		case "format":
+			if _, ok := outputFormats[value]; !ok {
+				return nil, fmt.Errorf("%w: unsupported output format: %s", ErrInvalidOverride, value)
+			}
+			overrides.Format = value
+		case "viewport":
+			width, height, found := strings.Cut(strings.ToLower(value), "x")
+			w, errW := strconv.Atoi(width)
+			hgt, errH := strconv.Atoi(height)
+			if !found || errW != nil || errH != nil || w <= 0 || hgt <= 0 {
+				return nil, fmt.Errorf("%w: invalid viewport %q: expected WIDTHxHEIGHT", ErrInvalidOverride, value)
+			}
+			overrides.Width, overrides.Height = w, hgt
+		case "wait-for":
+			overrides.WaitFor = value
+		case "timeout":
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				return nil, fmt.Errorf("%w: invalid timeout %q", ErrInvalidOverride, value)
+			}
+			overrides.Timeout = time.Duration(seconds) * time.Second
+		case "no-cache":
+			overrides.NoCache, err = parseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid no-cache value: %v", ErrInvalidOverride, err)
+			}
+		default:
+			return nil, fmt.Errorf("%w: unknown override: %s", ErrInvalidOverride, name)
+		}
+	}
+	return overrides, nil
+}
+
+// requestOverrides verifies the render overrides of a request and returns it
+// stripped of override query parameters. Without a secret the feature is off:
+// headless-* parameters are left for upstream and no overrides apply.
+func (h *HeadlessProxy) requestOverrides(r *http.Request) (*http.Request, *renderOverrides, error) {
+	if h.OverrideSecret == "" {
+		return r, nil, nil
+	}
+
+	params := collectOverrides(r)
+	r = stripOverrideQuery(r)
+	overrides, err := h.parseOverrides(r, params)
+	return r, overrides, err
+}
+
+// overrideErrorStatus maps an override error to its HTTP status
+func overrideErrorStatus(err error) int {
+	if errors.Is(err, ErrOverrideSignature) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+// apply copies the overrides into the render options
+func (o *renderOverrides) apply(opts *renderOptions) {
+	if o.Format != "" {
+		opts.Format = o.Format
+	}
+	if o.Width > 0 {
+		device := EmulationProfile{}
+		if opts.Device != nil {
+			device = *opts.Device
+		}
+		device.Width, device.Height = o.Width, o.Height
+		opts.Device = &device
+		opts.Profile += "@" + strconv.Itoa(o.Width) + "x" + strconv.Itoa(o.Height)
+	}
+	opts.WaitFor = o.WaitFor
+	opts.Timeout = o.Timeout
+	opts.NoCache = o.NoCache
+}
@@ -0,0 +1,73 @@
+package headlessproxy
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOverrides(t *testing.T) {
+	hp := &HeadlessProxy{OverrideSecret: "secret"}
+	expires := strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+
+	sign := func(target string, params map[string]string) string {
+		r := stripOverrideQuery(httptest.NewRequest("GET", target, nil))
+		return overrideSignature("secret", "GET", r.URL.RequestURI(), params)
+	}
+
+	// A signed request is accepted and the override parameters are stripped
+	params := map[string]string{"expires": expires, "format": "png", "viewport": "1280x720"}
+	target := "http://example.com/page?a=1&headless-format=png&headless-viewport=1280x720&headless-expires=" + expires
+	req := httptest.NewRequest("GET", target+"&headless-signature="+sign(target, params), nil)
+
+	collected := collectOverrides(req)
+	req = stripOverrideQuery(req)
+	assert.Equal(t, "/page?a=1", req.URL.RequestURI())
+
+	overrides, err := hp.parseOverrides(req, collected)
+	require.NoError(t, err)
+	assert.Equal(t, "png", overrides.Format)
+	assert.Equal(t, 1280, overrides.Width)
+	assert.Equal(t, 720, overrides.Height)
+
+	// Tampering with a value invalidates the signature
+	collected["format"] = "pdf"
+	_, err = hp.parseOverrides(req, collected)
+	assert.ErrorIs(t, err, ErrOverrideSignature)
+
+	// Expired signatures are rejected
+	past := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	expired := map[string]string{"expires": past, "no-cache": "true"}
+	req = httptest.NewRequest("GET", "http://example.com/page", nil)
+	expired["signature"] = sign("http://example.com/page", expired)
+	_, err = hp.parseOverrides(req, expired)
+	assert.ErrorIs(t, err, ErrOverrideSignature)
+
+	// Without a secret, overrides are ignored
+	overrides, err = (&HeadlessProxy{}).parseOverrides(req, expired)
+	require.NoError(t, err)
+	assert.Nil(t, overrides)
+}
+
+func TestRequestOverridesDisabled(t *testing.T) {
+	// Without a secret, headless-* parameters belong to the site and reach upstream
+	hp := &HeadlessProxy{Upstream: "http://upstream.test"}
+	req := httptest.NewRequest("GET", "http://example.com/page?headless-foo=1&a=2", nil)
+	req.Header.Set("X-Headless-Format", "png")
+
+	stripped, overrides, err := hp.requestOverrides(req)
+	require.NoError(t, err)
+	assert.Nil(t, overrides)
+	assert.Same(t, req, stripped)
+	assert.Equal(t, "http://upstream.test/page?headless-foo=1&a=2", hp.targetURL(stripped))
+
+	// With a secret, they are taken as overrides and stripped
+	hp.OverrideSecret = "secret"
+	stripped, _, err = hp.requestOverrides(req)
+	assert.ErrorIs(t, err, ErrOverrideSignature)
+	assert.Equal(t, "http://upstream.test/page?a=2", hp.targetURL(stripped))
+}
@@ -0,0 +1,318 @@
+package headlessproxy
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+	"go.uber.org/zap"
+)
+
+// browserProxy is an egress proxy renders can be routed through
+type browserProxy struct {
+	url *url.URL
+
+	// Proxy server as passed to Chrome, without credentials
+	server string
+
+	username string
+	password string
+
+	healthy bool
+}
+
+// ProxyPool selects egress proxies for browsers and renders and health-checks them
+type ProxyPool struct {
+	proxy *HeadlessProxy
+
+	mu       sync.Mutex
+	proxies  []*browserProxy
+	next     int
+	browsers map[*rod.Browser]*browserProxy
+}
+
+// parseBrowserProxy parses an http, https or socks5 proxy URL
+func parseBrowserProxy(raw string) (*browserProxy, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid browser proxy %q: %v", raw, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid browser proxy %q: scheme must be http, https or socks5", raw)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid browser proxy %q: missing host", raw)
+	}
+
+	bp := &browserProxy{
+		url:     u,
+		server:  u.Scheme + "://" + u.Host,
+		healthy: true,
+	}
+	if u.User != nil {
+		// Chrome answers auth challenges from HTTP proxies only
+		if u.Scheme == "socks5" {
+			return nil, fmt.Errorf("invalid browser proxy %q: SOCKS5 proxies can't use credentials", raw)
+		}
+		bp.username = u.User.Username()
+		bp.password, _ = u.User.Password()
+	}
+	return bp, nil
+}
+
+// NewProxyPool creates a new proxy pool for the configured proxies
+func NewProxyPool(proxy *HeadlessProxy) (*ProxyPool, error) {
+	p := &ProxyPool{
+		proxy:    proxy,
+		browsers: make(map[*rod.Browser]*browserProxy),
+	}
+	for _, raw := range proxy.BrowserProxies {
+		bp, err := parseBrowserProxy(raw)
+		if err != nil {
+			return nil, err
+		}
+		p.proxies = append(p.proxies, bp)
+	}
+	return p, nil
+}
+
+// Select picks a proxy. With sticky selection the same key always gets the
+// same proxy while it is healthy; otherwise proxies are used in turn.
+// Unhealthy proxies are skipped unless none is healthy.
+func (p *ProxyPool) Select(key string) *browserProxy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	start := p.next
+	if p.proxy.BrowserProxySelection == "sticky" && key != "" {
+		hash := fnv.New32a()
+		hash.Write([]byte(key))
+		start = int(hash.Sum32() % uint32(len(p.proxies)))
+	} else {
+		p.next = (p.next + 1) % len(p.proxies)
+	}
+
+	for i := 0; i < len(p.proxies); i++ {
+		bp := p.proxies[(start+i)%len(p.proxies)]
+		if bp.healthy {
+			return bp
+		}
+	}
+	return p.proxies[start%len(p.proxies)]
+}
+
+// Assign records the proxy a browser was launched with
+func (p *ProxyPool) Assign(browser *rod.Browser, bp *browserProxy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.browsers[browser] = bp
+}
+
+// ForBrowser returns the proxy a browser was launched with
+func (p *ProxyPool) ForBrowser(browser *rod.Browser) *browserProxy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.browsers[browser]
+}
+
+// BrowsersBehind returns the browsers launched with a proxy
+func (p *ProxyPool) BrowsersBehind(bp *browserProxy) []*rod.Browser {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var browsers []*rod.Browser
+	for browser, assigned := range p.browsers {
+		if assigned == bp {
+			browsers = append(browsers, browser)
+		}
+	}
+	return browsers
+}
+
+// BrowserHealthy reports whether a browser goes through a healthy proxy, or
+// through none
+func (p *ProxyPool) BrowserHealthy(browser *rod.Browser) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	bp, ok := p.browsers[browser]
+	return !ok || bp.healthy
+}
+
+// Release forgets a closed browser
+func (p *ProxyPool) Release(browser *rod.Browser) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.browsers, browser)
+}
+
+// ProxyURL routes plain HTTP client requests through the pool, for http.Transport
+func (p *ProxyPool) ProxyURL(*http.Request) (*url.URL, error) {
+	return p.Select("").url, nil
+}
+
+// StartHealthChecks periodically checks that each proxy can reach the upstream
+func (p *ProxyPool) StartHealthChecks(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Duration(p.proxy.BrowserProxyCheckInterval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			p.checkAll(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// checkAll checks every proxy and records the results
+func (p *ProxyPool) checkAll(ctx context.Context) {
+	for _, bp := range p.proxies {
+		err := p.check(ctx, bp)
+
+		p.mu.Lock()
+		changed := bp.healthy != (err == nil)
+		bp.healthy = err == nil
+		p.mu.Unlock()
+
+		if changed && err != nil {
+			p.proxy.logger.Warn("browser proxy unhealthy", zap.String("proxy", bp.server), zap.Error(err))
+			// Browsers launched behind the proxy are replaced by ones behind a healthy proxy
+			if p.proxy.monitor != nil {
+				p.proxy.monitor.RecycleBrowsers(p.BrowsersBehind(bp))
+			}
+		} else if changed {
+			p.proxy.logger.Info("browser proxy healthy again", zap.String("proxy", bp.server))
+		}
+
+		up := 0.0
+		if err == nil {
+			up = 1
+		}
+		p.proxy.metrics.browserProxyUp.WithLabelValues(bp.server).Set(up)
+	}
+}
+
+// check requests the check URL through a proxy. Any response but a proxy
+// error counts as healthy, since the upstream itself may answer with an error.
+func (p *ProxyPool) check(ctx context.Context, bp *browserProxy) error {
+	client := &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{Proxy: http.ProxyURL(bp.url)},
+	}
+	defer client.CloseIdleConnections()
+
+	checkURL := p.proxy.BrowserProxyCheckURL
+	if checkURL == "" {
+		checkURL = p.proxy.Upstream
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, checkURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusProxyAuthRequired || resp.StatusCode == http.StatusBadGateway {
+		return fmt.Errorf("proxy responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Status reports whether each proxy is healthy
+func (p *ProxyPool) Status() map[string]bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := make(map[string]bool, len(p.proxies))
+	for _, bp := range p.proxies {
+		status[bp.server] = bp.healthy
+	}
+	return status
+}
+
+// proxyContext returns the browser to open a render's page in, with the proxy
+// it goes through. With context scope, each render gets its own browser context
+// using a freshly selected proxy; release disposes of it.
+func (h *HeadlessProxy) proxyContext(browser *rod.Browser, r *http.Request) (*rod.Browser, *browserProxy, func(), error) {
+	if h.proxyPool == nil {
+		return browser, nil, func() {}, nil
+	}
+	if h.BrowserProxyScope != "context" {
+		return browser, h.proxyPool.ForBrowser(browser), func() {}, nil
+	}
+
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+	bp := h.proxyPool.Select(clientIP)
+
+	res, err := proto.TargetCreateBrowserContext{ProxyServer: bp.server}.Call(browser)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create browser context: %v", err)
+	}
+
+	contextBrowser := *browser
+	contextBrowser.BrowserContextID = res.BrowserContextID
+	release := func() {
+		err := proto.TargetDisposeBrowserContext{BrowserContextID: res.BrowserContextID}.Call(browser)
+		if err != nil {
+			h.logger.Warn("failed to dispose browser context", zap.Error(err))
+		}
+	}
+	return &contextBrowser, bp, release, nil
+}
+
+// handleProxyAuth answers proxy auth challenges of a page with the proxy's
+// credentials through the Fetch domain. Unless another handler already
+// continues paused requests, continueRequests must be set.
+func handleProxyAuth(ctx context.Context, page *rod.Page, bp *browserProxy, continueRequests bool) error {
+	if bp == nil || bp.username == "" {
+		return nil
+	}
+
+	err := proto.FetchEnable{
+		Patterns:           []*proto.FetchRequestPattern{{URLPattern: "*"}},
+		HandleAuthRequests: true,
+	}.Call(page)
+	if err != nil {
+		return fmt.Errorf("failed to enable proxy authentication: %v", err)
+	}
+
+	wait := page.Context(ctx).EachEvent(func(e *proto.FetchAuthRequired) {
+		// Only proxies get the credentials; server challenges keep the default behavior
+		response := proto.FetchAuthChallengeResponseResponseDefault
+		if e.AuthChallenge.Source == proto.FetchAuthChallengeSourceProxy {
+			response = proto.FetchAuthChallengeResponseResponseProvideCredentials
+		}
+		_ = proto.FetchContinueWithAuth{
+			RequestID: e.RequestID,
+			AuthChallengeResponse: &proto.FetchAuthChallengeResponse{
+				Response: response,
+				Username: bp.username,
+				Password: bp.password,
+			},
+		}.Call(page)
+	}, func(e *proto.FetchRequestPaused) {
+		if continueRequests {
+			_ = proto.FetchContinueRequest{RequestID: e.RequestID}.Call(page)
+		}
+	})
+	go wait()
+	return nil
+}
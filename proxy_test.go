@@ -0,0 +1,83 @@
+package headlessproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-rod/rod"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyPoolSelect(t *testing.T) {
+	hp := &HeadlessProxy{BrowserProxies: []string{"http://a:3128", "http://user:pass@b:3128", "socks5://c:1080"}}
+	pool, err := NewProxyPool(hp)
+	require.NoError(t, err)
+
+	// Round robin uses each proxy in turn, skipping unhealthy ones
+	assert.Equal(t, "http://a:3128", pool.Select("").server)
+	assert.Equal(t, "http://b:3128", pool.Select("").server)
+	pool.proxies[2].healthy = false
+	assert.Equal(t, "http://a:3128", pool.Select("").server)
+
+	// Sticky selection keeps a client on one proxy
+	hp.BrowserProxySelection = "sticky"
+	first := pool.Select("192.0.2.1")
+	for i := 0; i < 5; i++ {
+		assert.Same(t, first, pool.Select("192.0.2.1"))
+	}
+
+	// Credentials are kept out of the server passed to Chrome
+	assert.Equal(t, "user", pool.proxies[1].username)
+	assert.Equal(t, "pass", pool.proxies[1].password)
+
+	_, err = parseBrowserProxy("socks5://user:pass@c:1080")
+	assert.Error(t, err)
+	_, err = parseBrowserProxy("ftp://c:21")
+	assert.Error(t, err)
+}
+
+func TestProxyPoolCheck(t *testing.T) {
+	// A local forward proxy answering for any absolute-form request
+	proxied := 0
+	forward := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied++
+		assert.Equal(t, "upstream.test", r.URL.Host)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer forward.Close()
+
+	authenticating := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusProxyAuthRequired)
+	}))
+	defer authenticating.Close()
+
+	hp := &HeadlessProxy{
+		Upstream:       "http://upstream.test/",
+		BrowserProxies: []string{forward.URL, authenticating.URL},
+	}
+	pool, err := NewProxyPool(hp)
+	require.NoError(t, err)
+
+	assert.NoError(t, pool.check(context.Background(), pool.proxies[0]))
+	assert.Equal(t, 1, proxied)
+	assert.Error(t, pool.check(context.Background(), pool.proxies[1]))
+}
+
+func TestProxyPoolBrowsers(t *testing.T) {
+	pool, err := NewProxyPool(&HeadlessProxy{BrowserProxies: []string{"http://a:3128", "http://b:3128"}})
+	require.NoError(t, err)
+
+	first, second := &rod.Browser{}, &rod.Browser{}
+	pool.Assign(first, pool.proxies[0])
+	pool.Assign(second, pool.proxies[1])
+	assert.Equal(t, []*rod.Browser{first}, pool.BrowsersBehind(pool.proxies[0]))
+
+	// Browsers behind an unhealthy proxy are not returned to the browser pool
+	pool.proxies[0].healthy = false
+	assert.False(t, pool.BrowserHealthy(first))
+	assert.True(t, pool.BrowserHealthy(second))
+	assert.True(t, pool.BrowserHealthy(&rod.Browser{}))
+}
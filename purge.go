@@ -0,0 +1,176 @@
+package headlessproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// cacheInstances tracks the provisioned handlers with a cache, for the purge API
+var cacheInstances = struct {
+	sync.Mutex
+	proxies map[*HeadlessProxy]struct{}
+}{proxies: make(map[*HeadlessProxy]struct{})}
+
+// registerCache makes the cache of a handler reachable from the purge API
+func registerCache(h *HeadlessProxy) {
+	cacheInstances.Lock()
+	defer cacheInstances.Unlock()
+	cacheInstances.proxies[h] = struct{}{}
+}
+
+// unregisterCache forgets the cache of a handler being cleaned up
+func unregisterCache(h *HeadlessProxy) {
+	cacheInstances.Lock()
+	defer cacheInstances.Unlock()
+	delete(cacheInstances.proxies, h)
+}
+
+// PurgeAdmin is an admin API module removing cached renders
+type PurgeAdmin struct{}
+
+// CaddyModule returns the Caddy module information.
+func (PurgeAdmin) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.headless_proxy",
+		New: func() caddy.Module { return new(PurgeAdmin) },
+	}
+}
+
+// Routes returns the admin routes of the module
+func (PurgeAdmin) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: "/headless_proxy/purge",
+			Handler: caddy.AdminHandlerFunc(handlePurge),
+		},
+	}
+}
+
+// PurgeRequest selects the cached renders to remove. An entry is removed if
+// any criterion matches it.
+type PurgeRequest struct {
+	// Exact URLs, either absolute or as path and query
+	URLs []string `json:"urls,omitempty"`
+
+	// Path prefixes
+	Prefixes []string `json:"prefixes,omitempty"`
+
+	// Path patterns where * matches any characters
+	Patterns []string `json:"patterns,omitempty"`
+
+	// Surrogate keys from the upstream Surrogate-Key or Cache-Tag headers
+	SurrogateKeys []string `json:"surrogate_keys,omitempty"`
+
+	// Remove everything
+	All bool `json:"all,omitempty"`
+}
+
+// PurgeResponse reports how many cache entries were removed
+type PurgeResponse struct {
+	Removed int `json:"removed"`
+}
+
+// handlePurge removes the cached renders selected by a purge request from all handlers
+func handlePurge(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+
+	var req PurgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("invalid purge request: %v", err),
+		}
+	}
+	if !req.All && len(req.URLs) == 0 && len(req.Prefixes) == 0 && len(req.Patterns) == 0 && len(req.SurrogateKeys) == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("purge request selects nothing; set all to flush the cache"),
+		}
+	}
+
+	cacheInstances.Lock()
+	removed := 0
+	for h := range cacheInstances.proxies {
+		var match func(key string, entry *cacheEntry) bool
+		if !req.All {
+			// URLs are compared the way each handler builds its cache keys
+			match = func(_ string, entry *cacheEntry) bool {
+				return req.matches(h, entry)
+			}
+		}
+		n := h.cacheBackend.Purge(match)
+		h.logger.Info("cache purged", zap.Int("removed", n))
+		removed += n
+	}
+	cacheInstances.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(PurgeResponse{Removed: removed})
+}
+
+// matches reports whether a purge request selects a cache entry of a handler.
+// Exact URLs match regardless of query parameters the handler leaves out of
+// its cache keys and of parameter order.
+func (req *PurgeRequest) matches(h *HeadlessProxy, entry *cacheEntry) bool {
+	entryURL := h.normalizeURL(entry.URL)
+	pathAndQuery := entryURL
+	if i := strings.Index(entryURL, "://"); i >= 0 {
+		pathAndQuery = "/"
+		if j := strings.IndexByte(entryURL[i+3:], '/'); j >= 0 {
+			pathAndQuery = entryURL[i+3+j:]
+		}
+	}
+	path, _, _ := strings.Cut(pathAndQuery, "?")
+
+	for _, u := range req.URLs {
+		if u = h.normalizeURL(u); u == entryURL || u == pathAndQuery {
+			return true
+		}
+	}
+	for _, prefix := range req.Prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	for _, pattern := range req.Patterns {
+		if wildcardMatch(pattern, path) {
+			return true
+		}
+	}
+	for _, key := range req.SurrogateKeys {
+		for _, tag := range entry.Tags {
+			if key == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// surrogateKeys collects the surrogate keys of an upstream response, from
+// space-separated Surrogate-Key and comma-separated Cache-Tag headers
+func surrogateKeys(upstream http.Header) []string {
+	var keys []string
+	for _, value := range upstream.Values("Surrogate-Key") {
+		keys = append(keys, strings.Fields(value)...)
+	}
+	for _, value := range upstream.Values("Cache-Tag") {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				keys = append(keys, tag)
+			}
+		}
+	}
+	return keys
+}
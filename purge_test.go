@@ -0,0 +1,65 @@
+package headlessproxy
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPurgeRequestMatches(t *testing.T) {
+	h := &HeadlessProxy{CacheKeyQueryDeny: defaultQueryDeny}
+	entry := &cacheEntry{
+		URL:  "https://example.com/blog/2024/hello?page=2",
+		Tags: surrogateKeys(http.Header{"Surrogate-Key": {"post-42 blog"}, "Cache-Tag": {"author-7, home"}}),
+	}
+	assert.Equal(t, []string{"post-42", "blog", "author-7", "home"}, entry.Tags)
+
+	for _, req := range []PurgeRequest{
+		{URLs: []string{"https://example.com/blog/2024/hello?page=2"}},
+		{URLs: []string{"/blog/2024/hello?page=2"}},
+		{Prefixes: []string{"/blog/"}},
+		{Patterns: []string{"/blog/*/hello"}},
+		{SurrogateKeys: []string{"author-7"}},
+	} {
+		assert.True(t, req.matches(h, entry), "%+v", req)
+	}
+
+	for _, req := range []PurgeRequest{
+		{URLs: []string{"/blog/2024/hello"}},
+		{Prefixes: []string{"/news/"}},
+		{Patterns: []string{"/blog/*/bye"}},
+		{SurrogateKeys: []string{"post-43"}},
+	} {
+		assert.False(t, req.matches(h, entry), "%+v", req)
+	}
+}
+
+func TestPurgeRequestMatchesNormalizedURL(t *testing.T) {
+	h := &HeadlessProxy{CacheKeyQueryDeny: defaultQueryDeny}
+	entry := &cacheEntry{URL: "https://example.com/products?utm_source=mail&sort=price&page=2"}
+
+	// Tracking parameters and parameter order don't keep a URL from matching
+	for _, u := range []string{
+		"https://example.com/products?page=2&sort=price",
+		"/products?sort=price&page=2&utm_campaign=spring",
+		"/products?page=2&utm_source=mail&sort=price",
+	} {
+		req := PurgeRequest{URLs: []string{u}}
+		assert.True(t, req.matches(h, entry), u)
+	}
+
+	for _, u := range []string{
+		"/products?page=3&sort=price",
+		"/products?sort=price",
+		"/products",
+	} {
+		req := PurgeRequest{URLs: []string{u}}
+		assert.False(t, req.matches(h, entry), u)
+	}
+
+	// Only parameters allowed into the cache key count
+	h = &HeadlessProxy{CacheKeyQueryAllow: []string{"page"}}
+	req := PurgeRequest{URLs: []string{"/products?page=2"}}
+	assert.True(t, req.matches(h, entry))
+}
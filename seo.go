@@ -0,0 +1,272 @@
+package headlessproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-rod/rod"
+)
+
+// maxRawBodySize limits how much upstream HTML is read by the plain HTTP client
+const maxRawBodySize = 10 << 20
+
+// seoReport compares SEO-relevant markup of the raw upstream HTML and the rendered DOM
+type seoReport struct {
+	URL         string    `json:"url"`
+	Status      int       `json:"status,omitempty"`
+	XRobotsTag  string    `json:"x_robots_tag,omitempty"`
+	Raw         *seoAudit `json:"raw,omitempty"`
+	RawError    string    `json:"raw_error,omitempty"`
+	Rendered    *seoAudit `json:"rendered"`
+	RenderOnly  []string  `json:"render_only,omitempty"`
+	GeneratedAt string    `json:"generated_at"`
+}
+
+// seoAudit holds the SEO-relevant markup of a single document
+type seoAudit struct {
+	Title            string            `json:"title"`
+	MetaDescription  string            `json:"meta_description"`
+	Robots           []string          `json:"robots"`
+	Canonical        string            `json:"canonical"`
+	Hreflang         []seoHreflang     `json:"hreflang"`
+	Headings         []seoHeading      `json:"headings"`
+	OpenGraph        map[string]string `json:"open_graph"`
+	Twitter          map[string]string `json:"twitter"`
+	JSONLD           []seoJSONLD       `json:"json_ld"`
+	InternalLinks    int               `json:"internal_links"`
+	ExternalLinks    int               `json:"external_links"`
+	Images           int               `json:"images"`
+	ImagesMissingAlt []string          `json:"images_missing_alt"`
+}
+
+type seoHreflang struct {
+	Lang string `json:"lang"`
+	Href string `json:"href"`
+}
+
+type seoHeading struct {
+	Level int    `json:"level"`
+	Text  string `json:"text"`
+}
+
+// seoJSONLD is a JSON-LD block; Source holds the raw text until it is parsed
+type seoJSONLD struct {
+	Source string          `json:"source,omitempty"`
+	Types  []string        `json:"types,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// seoAuditScript extracts the audit fields from the live document and,
+// when given, from the raw upstream HTML parsed into a detached document.
+const seoAuditScript = `(raw, baseURL) => {
+	const base = new URL(baseURL);
+	const audit = (doc) => {
+		const meta = (sel) => { const el = doc.querySelector(sel); return el ? (el.getAttribute('content') || '') : ''; };
+		const tags = (prefix, attr) => {
+			const out = {};
+			doc.querySelectorAll('meta[' + attr + '^="' + prefix + '"]').forEach(el => {
+				out[el.getAttribute(attr)] = el.getAttribute('content') || '';
+			});
+			return out;
+		};
+		const robots = [];
+		doc.querySelectorAll('meta[name="robots" i], meta[name="googlebot" i]').forEach(el => {
+			(el.getAttribute('content') || '').split(',').forEach(d => { d = d.trim().toLowerCase(); if (d) robots.push(d); });
+		});
+		let internal = 0, external = 0;
+		doc.querySelectorAll('a[href]').forEach(a => {
+			try {
+				const u = new URL(a.getAttribute('href'), base);
+				if (u.protocol !== 'http:' && u.protocol !== 'https:') return;
+				if (u.host === base.host) internal++; else external++;
+			} catch (e) {}
+		});
+		const images = Array.from(doc.querySelectorAll('img'));
+		const canonical = doc.querySelector('link[rel="canonical" i]');
+		return {
+			title: doc.title || '',
+			meta_description: meta('meta[name="description" i]'),
+			robots: robots,
+			canonical: canonical ? (canonical.getAttribute('href') || '') : '',
+			hreflang: Array.from(doc.querySelectorAll('link[rel="alternate" i][hreflang]')).map(el => ({
+				lang: el.getAttribute('hreflang'), href: el.getAttribute('href') || ''
+			})),
+			headings: Array.from(doc.querySelectorAll('h1, h2, h3, h4, h5, h6')).map(el => ({
+				level: parseInt(el.tagName.substring(1), 10), text: (el.textContent || '').trim().replace(/\s+/g, ' ')
+			})),
+			open_graph: tags('og:', 'property'),
+			twitter: tags('twitter:', 'name'),
+			json_ld: Array.from(doc.querySelectorAll('script[type="application/ld+json" i]')).map(el => ({ source: el.textContent || '' })),
+			internal_links: internal,
+			external_links: external,
+			images: images.length,
+			images_missing_alt: images.filter(img => !img.hasAttribute('alt')).map(img => img.getAttribute('src') || '')
+		};
+	};
+	const result = { rendered: audit(document) };
+	if (raw !== null) {
+		result.raw = audit(new DOMParser().parseFromString(raw, 'text/html'));
+	}
+	return result;
+}`
+
+// buildSEOReport audits the rendered page against the raw upstream HTML
+func (h *HeadlessProxy) buildSEOReport(ctx context.Context, r *http.Request, page *rod.Page, targetURL string) (*seoReport, error) {
+	report := &seoReport{
+		URL:         targetURL,
+		GeneratedAt: time.Now().Format(time.RFC3339),
+	}
+
+	// The raw document is optional; a failed fetch still yields the rendered audit
+	var raw interface{}
+	body, headers, status, err := h.fetchUpstream(ctx, r, targetURL)
+	if err != nil {
+		report.RawError = err.Error()
+	} else {
+		raw = string(body)
+		report.Status = status
+		report.XRobotsTag = headers.Get("X-Robots-Tag")
+	}
+
+	obj, err := page.Eval(seoAuditScript, raw, targetURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run SEO audit: %v", err)
+	}
+
+	var audits struct {
+		Raw      *seoAudit `json:"raw"`
+		Rendered *seoAudit `json:"rendered"`
+	}
+	if err := obj.Value.Unmarshal(&audits); err != nil {
+		return nil, fmt.Errorf("failed to decode SEO audit: %v", err)
+	}
+
+	report.Raw = audits.Raw
+	report.Rendered = audits.Rendered
+	parseJSONLD(report.Raw)
+	parseJSONLD(report.Rendered)
+	report.RenderOnly = seoRenderOnly(report.Raw, report.Rendered)
+
+	return report, nil
+}
+
+// parseJSONLD parses the JSON-LD blocks of an audit, recording parse errors
+func parseJSONLD(audit *seoAudit) {
+	if audit == nil {
+		return
+	}
+
+	for i := range audit.JSONLD {
+		block := &audit.JSONLD[i]
+		var data interface{}
+		if err := json.Unmarshal([]byte(block.Source), &data); err != nil {
+			block.Error = err.Error()
+			continue
+		}
+		block.Data = json.RawMessage(block.Source)
+		block.Types = jsonLDTypes(data)
+		block.Source = ""
+	}
+}
+
+// jsonLDTypes collects the @type values of a JSON-LD document
+func jsonLDTypes(data interface{}) []string {
+	var types []string
+	switch v := data.(type) {
+	case []interface{}:
+		for _, item := range v {
+			types = append(types, jsonLDTypes(item)...)
+		}
+	case map[string]interface{}:
+		switch t := v["@type"].(type) {
+		case string:
+			types = append(types, t)
+		case []interface{}:
+			for _, item := range t {
+				if s, ok := item.(string); ok {
+					types = append(types, s)
+				}
+			}
+		}
+		if graph, ok := v["@graph"]; ok {
+			types = append(types, jsonLDTypes(graph)...)
+		}
+	}
+	return types
+}
+
+// seoRenderOnly lists the audit fields that are only present after rendering
+func seoRenderOnly(raw, rendered *seoAudit) []string {
+	if raw == nil || rendered == nil {
+		return nil
+	}
+
+	var fields []string
+	check := func(name string, rawEmpty, renderedEmpty bool) {
+		if rawEmpty && !renderedEmpty {
+			fields = append(fields, name)
+		}
+	}
+	check("title", raw.Title == "", rendered.Title == "")
+	check("meta_description", raw.MetaDescription == "", rendered.MetaDescription == "")
+	check("robots", len(raw.Robots) == 0, len(rendered.Robots) == 0)
+	check("canonical", raw.Canonical == "", rendered.Canonical == "")
+	check("hreflang", len(raw.Hreflang) == 0, len(rendered.Hreflang) == 0)
+	check("headings", len(raw.Headings) == 0, len(rendered.Headings) == 0)
+	check("open_graph", len(raw.OpenGraph) == 0, len(rendered.OpenGraph) == 0)
+	check("twitter", len(raw.Twitter) == 0, len(rendered.Twitter) == 0)
+	check("json_ld", len(raw.JSONLD) == 0, len(rendered.JSONLD) == 0)
+	check("internal_links", raw.InternalLinks == 0, rendered.InternalLinks == 0)
+	check("external_links", raw.ExternalLinks == 0, rendered.ExternalLinks == 0)
+	return fields
+}
+
+// newUpstreamRequest creates a plain GET request for the target, presenting
+// the same identity and forwarded headers as the browser
+func (h *HeadlessProxy) newUpstreamRequest(ctx context.Context, r *http.Request, targetURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := h.getRenderOptions(r)
+	req.Header.Set("User-Agent", h.renderUserAgent(opts))
+	if opts.AcceptLanguage != "" {
+		req.Header.Set("Accept-Language", opts.AcceptLanguage)
+	}
+	for _, header := range h.ForwardHeaders {
+		if value := r.Header.Get(header); value != "" {
+			req.Header.Set(header, value)
+		}
+	}
+	for _, cookie := range h.forwardedCookies(r) {
+		req.AddCookie(cookie)
+	}
+	return req, nil
+}
+
+// fetchUpstream fetches a URL with the plain HTTP client, without rendering
+func (h *HeadlessProxy) fetchUpstream(ctx context.Context, r *http.Request, targetURL string) ([]byte, http.Header, int, error) {
+	req, err := h.newUpstreamRequest(ctx, r, targetURL)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("%w: %v", ErrRequestFailed, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRawBodySize))
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("%w: %v", ErrRequestFailed, err)
+	}
+
+	return body, resp.Header, resp.StatusCode, nil
+}
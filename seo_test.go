@@ -0,0 +1,98 @@
+package headlessproxy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONLDTypes(t *testing.T) {
+	tests := []struct {
+		source string
+		types  []string
+	}{
+		{`{"@context": "https://schema.org", "@type": "Article"}`, []string{"Article"}},
+		// Array types keep their order and skip anything but strings
+		{`{"@type": ["Product", "Thing", 3, null]}`, []string{"Product", "Thing"}},
+		// Graph nodes follow the types of their container
+		{
+			`{"@type": "WebSite", "@graph": [
+				{"@type": "Organization"},
+				{"@type": ["WebPage", "ItemPage"], "@graph": [{"@type": "BreadcrumbList"}]},
+				{"name": "untyped"}
+			]}`,
+			[]string{"WebSite", "Organization", "WebPage", "ItemPage", "BreadcrumbList"},
+		},
+		{`{"@graph": {"@type": "Person"}}`, []string{"Person"}},
+		{`[{"@type": "Event"}, [{"@type": "Place"}], "text"]`, []string{"Event", "Place"}},
+		// Nested properties are not part of the document's types
+		{`{"@type": "Recipe", "author": {"@type": "Person"}}`, []string{"Recipe"}},
+		{`{"@type": {"@id": "x"}}`, nil},
+		{`"Article"`, nil},
+		{`null`, nil},
+	}
+	for _, tt := range tests {
+		var data interface{}
+		if err := json.Unmarshal([]byte(tt.source), &data); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, tt.types, jsonLDTypes(data), tt.source)
+	}
+}
+
+func TestParseJSONLD(t *testing.T) {
+	audit := &seoAudit{JSONLD: []seoJSONLD{
+		{Source: `{"@graph": [{"@type": "Organization"}, {"@type": ["WebPage", "FAQPage"]}]}`},
+		{Source: `{"@type": "Article",}`},
+		{Source: ``},
+	}}
+	parseJSONLD(audit)
+
+	// Parsed blocks keep their data and drop the source
+	assert.Equal(t, []string{"Organization", "WebPage", "FAQPage"}, audit.JSONLD[0].Types)
+	assert.Equal(t, json.RawMessage(`{"@graph": [{"@type": "Organization"}, {"@type": ["WebPage", "FAQPage"]}]}`), audit.JSONLD[0].Data)
+	assert.Empty(t, audit.JSONLD[0].Source)
+	assert.Empty(t, audit.JSONLD[0].Error)
+
+	// Broken blocks keep their source next to the error
+	for _, block := range audit.JSONLD[1:] {
+		assert.NotEmpty(t, block.Error)
+		assert.Nil(t, block.Data)
+		assert.Nil(t, block.Types)
+	}
+	assert.Equal(t, `{"@type": "Article",}`, audit.JSONLD[1].Source)
+
+	// A missing raw audit is left alone
+	parseJSONLD(nil)
+}
+
+func TestSEORenderOnly(t *testing.T) {
+	raw := &seoAudit{
+		Title:         "Shop",
+		Robots:        []string{"index"},
+		InternalLinks: 3,
+	}
+	rendered := &seoAudit{
+		Title:           "Shop - Shoes",
+		MetaDescription: "All shoes",
+		Robots:          []string{"noindex"},
+		Canonical:       "https://shop.test/shoes",
+		Headings:        []seoHeading{{Level: 1, Text: "Shoes"}},
+		OpenGraph:       map[string]string{"og:title": "Shoes"},
+		JSONLD:          []seoJSONLD{{Types: []string{"Product"}}},
+		InternalLinks:   40,
+		ExternalLinks:   2,
+	}
+	assert.Equal(t, []string{
+		"meta_description", "canonical", "headings", "open_graph", "json_ld", "external_links",
+	}, seoRenderOnly(raw, rendered))
+
+	// Fields lost or already present in the raw HTML are not render-only
+	assert.Nil(t, seoRenderOnly(rendered, raw))
+	assert.Nil(t, seoRenderOnly(rendered, rendered))
+
+	// Without the raw HTML nothing can be compared
+	assert.Nil(t, seoRenderOnly(nil, rendered))
+	assert.Nil(t, seoRenderOnly(raw, nil))
+}
@@ -0,0 +1,117 @@
+package headlessproxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// ThrottleProfile describes the network conditions and CPU speed emulated while rendering
+type ThrottleProfile struct {
+	// Whether the page is offline
+	Offline bool `json:"offline,omitempty"`
+
+	// Additional round-trip latency in milliseconds
+	Latency float64 `json:"latency,omitempty"`
+
+	// Maximum download and upload throughput in kbit/s (0 is unlimited)
+	Download float64 `json:"download,omitempty"`
+	Upload   float64 `json:"upload,omitempty"`
+
+	// CPU slowdown factor, e.g. 4 for a mid-range phone (0 or 1 is full speed)
+	CPU float64 `json:"cpu,omitempty"`
+}
+
+// builtinThrottles are the throttle profiles available without configuration,
+// matching the presets of Chrome DevTools and Lighthouse
+var builtinThrottles = map[string]ThrottleProfile{
+	"offline": {Offline: true},
+	"slow-3g": {Latency: 2000, Download: 400, Upload: 400, CPU: 4},
+	"fast-3g": {Latency: 562.5, Download: 1440, Upload: 675, CPU: 4},
+	"slow-4g": {Latency: 150, Download: 1638.4, Upload: 675, CPU: 4},
+}
+
+// lookupThrottle finds a configured or built-in throttle profile by name
+func (h *HeadlessProxy) lookupThrottle(name string) (ThrottleProfile, bool) {
+	if throttle, ok := h.Throttles[name]; ok {
+		return throttle, true
+	}
+	throttle, ok := builtinThrottles[name]
+	return throttle, ok
+}
+
+// selectThrottle picks the throttle profile for a request: the trusted header
+// wins over the configured default
+func (h *HeadlessProxy) selectThrottle(r *http.Request) (string, *ThrottleProfile) {
+	if h.ThrottleHeader != "" {
+		if name := strings.TrimSpace(r.Header.Get(h.ThrottleHeader)); name != "" {
+			if throttle, ok := h.lookupThrottle(name); ok {
+				return name, &throttle
+			}
+		}
+	}
+
+	if h.Throttle != "" {
+		if throttle, ok := h.lookupThrottle(h.Throttle); ok {
+			return h.Throttle, &throttle
+		}
+	}
+
+	return "", nil
+}
+
+// throttleVary returns the request headers the throttle profile depends on
+func (h *HeadlessProxy) throttleVary() []string {
+	if h.ThrottleHeader == "" {
+		return nil
+	}
+	return []string{h.ThrottleHeader}
+}
+
+// applyThrottle emulates network conditions and CPU speed on a page before navigation
+func applyThrottle(page *rod.Page, throttle *ThrottleProfile) error {
+	err := proto.NetworkEnable{}.Call(page)
+	if err != nil {
+		return fmt.Errorf("failed to enable network domain: %v", err)
+	}
+
+	// CDP expects bytes per second, with -1 disabling the limit
+	throughput := func(kbps float64) float64 {
+		if kbps <= 0 {
+			return -1
+		}
+		return kbps * 1000 / 8
+	}
+
+	err = proto.NetworkEmulateNetworkConditions{
+		Offline:            throttle.Offline,
+		Latency:            throttle.Latency,
+		DownloadThroughput: throughput(throttle.Download),
+		UploadThroughput:   throughput(throttle.Upload),
+	}.Call(page)
+	if err != nil {
+		return fmt.Errorf("failed to emulate network conditions: %v", err)
+	}
+
+	if throttle.CPU > 1 {
+		err = proto.EmulationSetCPUThrottlingRate{Rate: throttle.CPU}.Call(page)
+		if err != nil {
+			return fmt.Errorf("failed to throttle CPU: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// performanceReport is the response of the performance output format
+type performanceReport struct {
+	URL          string                 `json:"url"`
+	Profile      string                 `json:"profile,omitempty"`
+	Throttle     string                 `json:"throttle,omitempty"`
+	Conditions   *ThrottleProfile       `json:"conditions,omitempty"`
+	RenderTimeMS int64                  `json:"render_time_ms"`
+	Performance  map[string]interface{} `json:"performance"`
+}
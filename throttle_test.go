@@ -0,0 +1,82 @@
+package headlessproxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectThrottle(t *testing.T) {
+	kiosk := ThrottleProfile{Latency: 40, Download: 10000, Upload: 5000}
+	h := &HeadlessProxy{
+		Throttle:       "fast-3g",
+		ThrottleHeader: "X-Throttle",
+		Throttles: map[string]ThrottleProfile{
+			"kiosk": kiosk,
+			// Configured profiles shadow built-in ones
+			"slow-3g": {Latency: 1000},
+		},
+	}
+
+	tests := []struct {
+		header   string
+		name     string
+		throttle ThrottleProfile
+	}{
+		{"kiosk", "kiosk", kiosk},
+		{" offline ", "offline", builtinThrottles["offline"]},
+		{"slow-3g", "slow-3g", ThrottleProfile{Latency: 1000}},
+		// Unknown or missing names fall back to the default
+		{"", "fast-3g", builtinThrottles["fast-3g"]},
+		{"unknown", "fast-3g", builtinThrottles["fast-3g"]},
+		{"Offline", "fast-3g", builtinThrottles["fast-3g"]},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.header != "" {
+			r.Header.Set("X-Throttle", tt.header)
+		}
+		name, throttle := h.selectThrottle(r)
+		assert.Equal(t, tt.name, name, tt.header)
+		if assert.NotNil(t, throttle, tt.header) {
+			assert.Equal(t, tt.throttle, *throttle, tt.header)
+		}
+	}
+
+	// Without a default, unknown names select nothing
+	h.Throttle = ""
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Throttle", "unknown")
+	name, throttle := h.selectThrottle(r)
+	assert.Equal(t, "", name)
+	assert.Nil(t, throttle)
+
+	// Without a header, the client can't pick a profile
+	h = &HeadlessProxy{Throttle: "slow-4g"}
+	name, _ = h.selectThrottle(r)
+	assert.Equal(t, "slow-4g", name)
+}
+
+func TestThrottleCacheKey(t *testing.T) {
+	h := &HeadlessProxy{ThrottleHeader: "X-Throttle"}
+	cacheKey := func(header string) string {
+		r := httptest.NewRequest("GET", "/page", nil)
+		if header != "" {
+			r.Header.Set("X-Throttle", header)
+		}
+		opts := &renderOptions{Format: "html"}
+		opts.Throttle, opts.Conditions = h.selectThrottle(r)
+		return h.getCacheKey(withRenderOptions(r, opts))
+	}
+
+	// Each profile is cached separately; unknown names share the unthrottled render
+	assert.NotEqual(t, cacheKey("slow-3g"), cacheKey("fast-3g"))
+	assert.NotEqual(t, cacheKey("slow-3g"), cacheKey(""))
+	assert.Equal(t, cacheKey("unknown"), cacheKey(""))
+
+	// The header is only part of Vary when it is used
+	assert.Equal(t, []string{"X-Throttle"}, h.throttleVary())
+	h.ThrottleHeader = ""
+	assert.Empty(t, h.throttleVary())
+}
@@ -1,10 +1,12 @@
 package headlessproxy
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 )
 
 // toJSONString converts a value to a JSON string
@@ -124,3 +126,37 @@ func truncateString(s string, maxLen int) string {
 func isHealthyStatusCode(statusCode int) bool {
 	return statusCode >= 200 && statusCode < 400
 }
+
+// requestURL returns the absolute URL of a request as the client sent it
+func requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+type requestIDCtxKey struct{}
+
+// withRequestID attaches the ID of a request to its context: the client's
+// X-Request-ID, or a generated one if missing. Everything logged or written
+// for the request then carries the same ID.
+func withRequestID(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(requestIDCtxKey{}).(string); ok {
+		return r
+	}
+	requestID := r.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return r.WithContext(context.WithValue(r.Context(), requestIDCtxKey{}, requestID))
+}
+
+// getRequestID returns the ID attached to a request by withRequestID. Requests
+// that didn't come through ServeHTTP, such as cache warming, get a new one.
+func getRequestID(r *http.Request) string {
+	if requestID, ok := r.Context().Value(requestIDCtxKey{}).(string); ok {
+		return requestID
+	}
+	return getRequestID(withRequestID(r))
+}
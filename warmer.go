@@ -0,0 +1,430 @@
+package headlessproxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// maxSitemapSize is the largest uncompressed sitemap the sitemap protocol allows
+const maxSitemapSize = 50 << 20
+
+// maxSitemapDepth limits how deeply sitemap indexes may nest
+const maxSitemapDepth = 3
+
+// sitemapDocument is either a urlset or a sitemap index
+type sitemapDocument struct {
+	XMLName  xml.Name          `xml:""`
+	URLs     []sitemapLocation `xml:"url"`
+	Sitemaps []sitemapLocation `xml:"sitemap"`
+}
+
+// sitemapLocation is a URL listed by a sitemap, with its last modification
+type sitemapLocation struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// warmURL is a page to render into the cache
+type warmURL struct {
+	Loc     string
+	LastMod time.Time
+}
+
+// lastModLayouts are the W3C datetime formats sitemaps use for lastmod
+var lastModLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+}
+
+// parseLastMod parses a sitemap lastmod, returning the zero time if it is missing or invalid
+func parseLastMod(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range lastModLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// parseSitemap decodes a sitemap or sitemap index, gzip-compressed or not
+func parseSitemap(data []byte) (*sitemapDocument, error) {
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		data, err = io.ReadAll(io.LimitReader(zr, maxSitemapSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > maxSitemapSize {
+			return nil, fmt.Errorf("sitemap exceeds %d bytes", maxSitemapSize)
+		}
+	}
+
+	doc := &sitemapDocument{}
+	if err := xml.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+	if doc.XMLName.Local != "urlset" && doc.XMLName.Local != "sitemapindex" {
+		return nil, fmt.Errorf("unexpected root element %s", doc.XMLName.Local)
+	}
+	return doc, nil
+}
+
+// WarmerStatus describes the progress of the cache warmer
+type WarmerStatus struct {
+	Running      bool      `json:"running"`
+	Sitemaps     int       `json:"sitemaps"`
+	URLs         int       `json:"urls"`
+	Rendered     int       `json:"rendered"`
+	Skipped      int       `json:"skipped"`
+	Failed       int       `json:"failed"`
+	LastStarted  time.Time `json:"last_started"`
+	LastFinished time.Time `json:"last_finished"`
+	NextRun      time.Time `json:"next_run"`
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+// CacheWarmer renders the pages listed in sitemaps into the cache in the
+// background, with a bounded share of the browser pool
+type CacheWarmer struct {
+	proxy *HeadlessProxy
+
+	mu     sync.Mutex
+	status WarmerStatus
+}
+
+// NewCacheWarmer creates a new cache warmer
+func NewCacheWarmer(proxy *HeadlessProxy) *CacheWarmer {
+	return &CacheWarmer{proxy: proxy}
+}
+
+// StartWarming warms the cache now and then every warm_interval
+func (w *CacheWarmer) StartWarming(ctx context.Context) {
+	go func() {
+		interval := time.Duration(w.proxy.WarmInterval) * time.Second
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			w.run(ctx)
+
+			w.mu.Lock()
+			w.status.NextRun = time.Now().Add(interval)
+			w.mu.Unlock()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Status returns a snapshot of the warmer's progress
+func (w *CacheWarmer) Status() WarmerStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+// run reads the sitemaps and warms each listed URL once
+func (w *CacheWarmer) run(ctx context.Context) {
+	h := w.proxy
+	started := time.Now()
+
+	urls, sitemaps, err := w.collect(ctx)
+	w.mu.Lock()
+	w.status = WarmerStatus{
+		Running:      true,
+		Sitemaps:     sitemaps,
+		URLs:         len(urls),
+		LastStarted:  started,
+		LastFinished: w.status.LastFinished,
+	}
+	if err != nil {
+		// Warm what could be read rather than nothing
+		w.status.LastError = err.Error()
+		h.logger.Warn("failed to read sitemap", zap.Error(err))
+	}
+	w.mu.Unlock()
+	h.metrics.cacheWarmPending.Set(float64(len(urls)))
+
+	jobs := make(chan warmURL)
+	var wg sync.WaitGroup
+	for i := 0; i < h.WarmConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range jobs {
+				result, err := w.warm(ctx, u)
+				w.record(u, result, err)
+			}
+		}()
+	}
+
+feed:
+	for _, u := range urls {
+		select {
+		case jobs <- u:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	finished := time.Now()
+	w.mu.Lock()
+	w.status.Running = false
+	w.status.LastFinished = finished
+	status := w.status
+	w.mu.Unlock()
+	h.metrics.cacheWarmPending.Set(0)
+	h.metrics.cacheWarmLastRun.Set(float64(finished.Unix()))
+
+	h.logger.Info("cache warming finished",
+		zap.Int("urls", status.URLs),
+		zap.Int("rendered", status.Rendered),
+		zap.Int("skipped", status.Skipped),
+		zap.Int("failed", status.Failed),
+		zap.Duration("duration", finished.Sub(started)),
+	)
+}
+
+// record counts the outcome of warming a URL
+func (w *CacheWarmer) record(u warmURL, result string, err error) {
+	h := w.proxy
+	if err != nil {
+		h.logger.Debug("cache warming failed",
+			zap.String("url", u.Loc),
+			zap.Error(err))
+	}
+
+	w.mu.Lock()
+	switch result {
+	case "rendered":
+		w.status.Rendered++
+	case "skipped":
+		w.status.Skipped++
+	default:
+		w.status.Failed++
+	}
+	w.mu.Unlock()
+
+	h.metrics.cacheWarmURLsTotal.WithLabelValues(result).Inc()
+	h.metrics.cacheWarmPending.Dec()
+}
+
+// collect reads all configured sitemaps, following sitemap indexes, and
+// returns the distinct URLs they list with their latest lastmod
+func (w *CacheWarmer) collect(ctx context.Context) ([]warmURL, int, error) {
+	var (
+		urls     []warmURL
+		index    = make(map[string]int)
+		visited  = make(map[string]bool)
+		firstErr error
+	)
+
+	var read func(source string, depth int)
+	read = func(source string, depth int) {
+		if visited[source] || ctx.Err() != nil {
+			return
+		}
+		visited[source] = true
+
+		doc, err := w.readSitemap(ctx, source)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %v", source, err)
+			}
+			return
+		}
+
+		for _, loc := range doc.URLs {
+			u := warmURL{Loc: strings.TrimSpace(loc.Loc), LastMod: parseLastMod(loc.LastMod)}
+			if u.Loc == "" {
+				continue
+			}
+			if i, ok := index[u.Loc]; ok {
+				if u.LastMod.After(urls[i].LastMod) {
+					urls[i].LastMod = u.LastMod
+				}
+				continue
+			}
+			index[u.Loc] = len(urls)
+			urls = append(urls, u)
+		}
+
+		for _, sitemap := range doc.Sitemaps {
+			if depth >= maxSitemapDepth {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s: sitemap indexes nested deeper than %d levels", source, maxSitemapDepth)
+				}
+				return
+			}
+			read(strings.TrimSpace(sitemap.Loc), depth+1)
+		}
+	}
+
+	for _, source := range w.proxy.WarmSitemaps {
+		read(source, 0)
+	}
+	return urls, len(visited), firstErr
+}
+
+// readSitemap loads a sitemap from a URL or from a local file
+func (w *CacheWarmer) readSitemap(ctx context.Context, source string) (*sitemapDocument, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		data, err := io.ReadAll(io.LimitReader(f, maxSitemapSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > maxSitemapSize {
+			return nil, fmt.Errorf("sitemap exceeds %d bytes", maxSitemapSize)
+		}
+		return parseSitemap(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", w.proxy.defaultUserAgent())
+
+	resp, err := w.proxy.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSitemapSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSitemapSize {
+		return nil, fmt.Errorf("sitemap exceeds %d bytes", maxSitemapSize)
+	}
+	return parseSitemap(data)
+}
+
+// warm renders a sitemap URL into the cache unless its cached render is still
+// current. It returns rendered, skipped or failed.
+func (w *CacheWarmer) warm(ctx context.Context, u warmURL) (string, error) {
+	h := w.proxy
+
+	r, err := newWarmRequest(ctx, u.Loc)
+	if err != nil {
+		return "failed", err
+	}
+	opts := h.defaultRenderOptions(r)
+	r = withRenderOptions(r, opts)
+
+	// Pages that are never cached gain nothing from warming
+	if h.CacheTTL <= 0 || h.getCacheKey(r) == "" {
+		return "skipped", nil
+	}
+
+	cached := h.getCachedResponse(r)
+	if cached != nil {
+		changed := !u.LastMod.IsZero() && u.LastMod.After(cached.Created)
+		switch {
+		case h.WarmRefresh == "always" || changed:
+			// A render is due, and upstream revalidation would only delay it
+			cached = nil
+		case cached.fresh(time.Now()):
+			return "skipped", nil
+		}
+	}
+
+	if !w.waitForCapacity(ctx) {
+		return "failed", ctx.Err()
+	}
+
+	result, err := h.renderCoalesced(r, opts, cached)
+	if err != nil {
+		return "failed", err
+	}
+	if result.StatusCode >= 400 {
+		return "failed", fmt.Errorf("render returned status %d", result.StatusCode)
+	}
+	return "rendered", nil
+}
+
+// waitForCapacity holds a warm render back while the renders in flight use up
+// the warmer's share of the browser pool, so client requests come first. It
+// returns false if the context ends while waiting.
+func (w *CacheWarmer) waitForCapacity(ctx context.Context) bool {
+	limit := int64(w.proxy.WarmPoolShare * float64(w.proxy.MaxBrowsers))
+	if limit < 1 {
+		limit = 1
+	}
+
+	for w.proxy.activeRenders.Load() >= limit {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+	return true
+}
+
+// newWarmRequest builds the request of a client visiting a sitemap URL, with
+// the placeholders Caddy would set for it
+func newWarmRequest(ctx context.Context, loc string) (*http.Request, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, loc, nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.URL.Host == "" {
+		return nil, fmt.Errorf("sitemap URL is not absolute: %s", loc)
+	}
+	r.RequestURI = r.URL.RequestURI()
+	if r.URL.Scheme == "https" {
+		r.TLS = &tls.ConnectionState{ServerName: r.URL.Hostname()}
+	}
+	return caddyhttp.PrepareRequest(r, caddy.NewReplacer(), nil, nil), nil
+}
+
+// defaultRenderOptions selects the render options of a request without client
+// preferences, as ServeHTTP would for a client sending no hints
+func (h *HeadlessProxy) defaultRenderOptions(r *http.Request) *renderOptions {
+	opts := &renderOptions{Format: h.Output}
+	if format, ok := negotiateFormat("", h.Formats); ok {
+		opts.Format = format
+	}
+	opts.Profile, opts.Device = h.selectProfile(r)
+	h.resolveIdentity(r, opts)
+	h.resolveLocale(r, opts)
+	h.resolveMedia(r, opts)
+	opts.Throttle, opts.Conditions = h.selectThrottle(r)
+	return opts
+}
@@ -0,0 +1,72 @@
+package headlessproxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSitemap(t *testing.T) {
+	doc, err := parseSitemap([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc>https://example.com/</loc><lastmod>2024-05-01</lastmod></url>
+	<url><loc>https://example.com/about</loc></url>
+</urlset>`))
+	require.NoError(t, err)
+	assert.Equal(t, []sitemapLocation{
+		{Loc: "https://example.com/", LastMod: "2024-05-01"},
+		{Loc: "https://example.com/about"},
+	}, doc.URLs)
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write([]byte(`<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<sitemap><loc>https://example.com/sitemap-posts.xml.gz</loc></sitemap>
+</sitemapindex>`))
+	require.NoError(t, zw.Close())
+
+	doc, err = parseSitemap(buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, []sitemapLocation{{Loc: "https://example.com/sitemap-posts.xml.gz"}}, doc.Sitemaps)
+
+	_, err = parseSitemap([]byte(`<html><body>Not found</body></html>`))
+	assert.Error(t, err)
+}
+
+func TestParseLastMod(t *testing.T) {
+	assert.Equal(t, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), parseLastMod("2024-05-01"))
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC), parseLastMod("2024-05-01T12:30+02:00").UTC())
+	assert.Equal(t, time.Date(2024, 5, 1, 10, 30, 5, 0, time.UTC), parseLastMod("2024-05-01T10:30:05Z"))
+	assert.True(t, parseLastMod("yesterday").IsZero())
+}
+
+func TestCacheWarmerCollect(t *testing.T) {
+	dir := t.TempDir()
+	pages := filepath.Join(dir, "pages.xml")
+	posts := filepath.Join(dir, "posts.xml")
+	require.NoError(t, os.WriteFile(pages, []byte(`<urlset>
+	<url><loc>https://example.com/</loc><lastmod>2024-05-01</lastmod></url>
+	<url><loc>https://example.com/blog</loc><lastmod>2024-05-01</lastmod></url>
+</urlset>`), 0o644))
+	require.NoError(t, os.WriteFile(posts, []byte(`<urlset>
+	<url><loc>https://example.com/blog</loc><lastmod>2024-06-01</lastmod></url>
+	<url><loc>https://example.com/blog/hello</loc></url>
+</urlset>`), 0o644))
+
+	w := NewCacheWarmer(&HeadlessProxy{WarmSitemaps: []string{pages, posts, filepath.Join(dir, "missing.xml")}})
+	urls, sitemaps, err := w.collect(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 3, sitemaps)
+	assert.Equal(t, []warmURL{
+		{Loc: "https://example.com/", LastMod: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{Loc: "https://example.com/blog", LastMod: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{Loc: "https://example.com/blog/hello"},
+	}, urls)
+}